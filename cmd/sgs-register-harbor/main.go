@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -12,6 +13,8 @@ import (
 	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/bacchus-snu/sgs/pkg/secret"
 )
 
 func main() {
@@ -40,28 +43,53 @@ func run(ctx context.Context) error {
 		return fmt.Errorf("failed parsing workspace specification: %w", err)
 	}
 
-	harborURL := os.Getenv("SGS_HARBOR_URL")
-	if harborURL == "" {
-		return errors.New("SGS_HARBOR_URL is not set")
+	hapi, err := loadHarborEnv()
+	if err != nil {
+		return err
 	}
-	harborUsername := os.Getenv("SGS_HARBOR_USERNAME")
-	if harborUsername == "" {
-		return errors.New("SGS_HARBOR_USERNAME is not set")
+
+	// report per-workspace results to sgs, see worker.Result
+	enc := json.NewEncoder(os.Stdout)
+	report := func(idHash string, err error) {
+		res := struct {
+			IDHash  string `json:"idHash"`
+			Status  string `json:"status"`
+			Message string `json:"message,omitempty"`
+		}{IDHash: idHash, Status: "ready"}
+		if err != nil {
+			res.Status = "failed"
+			res.Message = err.Error()
+		}
+		enc.Encode(res)
 	}
-	harborPassword := os.Getenv("SGS_HARBOR_PASSWORD")
-	if harborPassword == "" {
-		return errors.New("SGS_HARBOR_PASSWORD is not set")
+
+	return runSync(ctx, hapi, want.Workspaces, report)
+}
+
+// loadHarborEnv loads the harbor client from SGS_HARBOR_URL,
+// SGS_HARBOR_USERNAME and SGS_HARBOR_PASSWORD, each of which may instead be
+// read from the file named by the *_FILE variable.
+func loadHarborEnv() (*harborImpl, error) {
+	var vals [3]string
+	for i, key := range []string{"SGS_HARBOR_URL", "SGS_HARBOR_USERNAME", "SGS_HARBOR_PASSWORD"} {
+		v, err := secret.Getenv(key)
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			return nil, fmt.Errorf("%s is not set", key)
+		}
+		vals[i] = v
 	}
 
-	hapi, err := loadHarbor(harborURL, harborUsername, harborPassword)
+	h, err := loadHarbor(vals[0], vals[1], vals[2])
 	if err != nil {
-		return fmt.Errorf("failed loading harbor client: %w", err)
+		return nil, fmt.Errorf("failed loading harbor client: %w", err)
 	}
-
-	return runSync(ctx, hapi, want.Workspaces)
+	return h, nil
 }
 
-func runSync(ctx context.Context, hapi harborIface, want []workspace) error {
+func runSync(ctx context.Context, hapi harborIface, want []workspace, report func(idHash string, err error)) error {
 	var outErr error
 
 	projects, err := hapi.listProjects(ctx)
@@ -91,11 +119,13 @@ func runSync(ctx context.Context, hapi harborIface, want []workspace) error {
 		}
 
 		slog.InfoContext(ctx, fmt.Sprintf("syncing workspace %q", p))
-		if err := syncWorkspace(ctx, hapi, want[ind]); err != nil {
+		err := syncWorkspace(ctx, hapi, want[ind])
+		if err != nil {
 			err = fmt.Errorf("failed syncing workspace %q: %w", p, err)
 			slog.WarnContext(ctx, err.Error())
 			outErr = errors.Join(outErr, err)
 		}
+		report(want[ind].IDHash, err)
 
 		// remove from reference slice
 		want = slices.Delete(want, ind, ind+1)
@@ -104,11 +134,13 @@ func runSync(ctx context.Context, hapi harborIface, want []workspace) error {
 	// create remaining workspaces
 	for _, w := range want {
 		slog.InfoContext(ctx, fmt.Sprintf("creating workspace %q", w.IDHash))
-		if err := createWorkspace(ctx, hapi, w); err != nil {
+		err := createWorkspace(ctx, hapi, w)
+		if err != nil {
 			err = fmt.Errorf("failed creating workspace %q: %w", w.IDHash, err)
 			slog.WarnContext(ctx, err.Error())
 			outErr = errors.Join(outErr, err)
 		}
+		report(w.IDHash, err)
 	}
 
 	return outErr
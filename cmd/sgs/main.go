@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 
 	"github.com/labstack/echo/v4"
 
@@ -15,33 +17,92 @@ import (
 	"github.com/bacchus-snu/sgs/pkg/auth"
 	"github.com/bacchus-snu/sgs/pkg/config"
 	"github.com/bacchus-snu/sgs/pkg/email"
+	"github.com/bacchus-snu/sgs/pkg/notify"
 	"github.com/bacchus-snu/sgs/worker"
 )
 
+const usage = `Usage:
+  sgs [--config FILE]                   run the server
+  sgs config validate [--config FILE]   validate and print the configuration
+
+Without --config, sgs.yaml (or .toml, .json) is looked up in the current
+directory and /etc/sgs. Environment variables override the config file.
+`
+
 func main() {
 	ctx := context.Background()
 	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
 	defer stop()
 	defer context.AfterFunc(ctx, stop)()
 
-	if err := run(ctx); err != nil {
+	flags := flag.NewFlagSet("sgs", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configFile := flags.String("config", os.Getenv("SGS_CONFIG"), "config file")
+	flags.Parse(os.Args[1:])
+
+	var err error
+	switch args := flags.Args(); {
+	case len(args) == 0:
+		err = run(ctx, *configFile)
+	case len(args) >= 2 && args[0] == "config" && args[1] == "validate":
+		// also accept flags after the subcommand
+		flags.Parse(args[2:])
+		err = validate(*configFile)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context) error {
+// validate prints the effective configuration, with secrets redacted, and
+// reports whether it is valid.
+func validate(configFile string) error {
+	cfg, err := config.Read(configFile)
+	if err != nil {
+		return err
+	}
+	err = cfg.Validate()
+
+	if f := config.File(); f != "" {
+		fmt.Fprintf(os.Stderr, "config file: %s\n", f)
+	} else {
+		fmt.Fprintln(os.Stderr, "config file: none, environment only")
+	}
+	if err := config.Print(os.Stdout, cfg); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	fmt.Fprintln(os.Stderr, "configuration is valid")
+	return nil
+}
+
+func run(ctx context.Context, configFile string) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	cfg, err := config.Load()
+	cfg, err := config.Load(configFile)
 	if err != nil {
 		return err
 	}
 
-	authSvc, err := auth.New(ctx, cfg.Auth)
-	if err != nil {
-		return err
+	var authSvc interface {
+		auth.Service
+		SetClientSecret(string)
+	}
+	if cfg.Auth.Dev {
+		log.Println("WARNING: development auth provider enabled, anyone can log in as anyone")
+		authSvc = auth.NewDev()
+	} else {
+		authSvc, err = auth.New(ctx, cfg.Auth)
+		if err != nil {
+			return err
+		}
 	}
 
 	repo, err := postgres.New(ctx, cfg.Postgres)
@@ -50,11 +111,22 @@ func run(ctx context.Context) error {
 	}
 	defer repo.Close()
 
-	queue := worker.NewQueue(
-		repo.Workspaces(),
-		worker.CmdWorker(cfg.Worker.Command),
-		time.Minute, 5*time.Minute,
-	)
+	e := echo.New()
+	// emails and chat messages link to the routes of e
+	links := controller.NewLinks(cfg.Controller, e)
+	policy := auth.NewPolicy(cfg.Auth)
+	emailSvc := email.NewSMTPService(cfg.Email, repo.MailingList(), repo.Users(), policy, links)
+
+	clusters := make([]worker.Cluster, len(cfg.Cluster.Clusters))
+	for i, cl := range cfg.Cluster.Clusters {
+		clusters[i] = worker.Cluster{Name: cl.Name, Worker: worker.NewClusterWorker(cfg.Worker, cl)}
+	}
+	queue := worker.NewClusterQueue(repo.Workspaces(), clusters, cfg.Cluster.Place, cfg.Worker)
+	if shared := worker.NewSharedWorker(cfg.Worker); shared != nil {
+		queue.UseSharedWorker(shared)
+	}
+	// only one replica runs the queue
+	queue.UseElector(repo.Elector("sgs-worker"))
 	queue.Enqueue() // enqueue update on startup
 
 	queueErrCh := make(chan error, 1)
@@ -63,11 +135,63 @@ func run(ctx context.Context) error {
 		queueErrCh <- queue.Start(ctx)
 	}()
 
-	// Initialize email service
-	emailSvc := email.NewSMTPService(cfg.Email)
+	// notifications are written to the outbox along with workspace changes,
+	// and delivered by any replica
+	channels := append([]notify.Channel{{Name: "email", Notifier: emailSvc}}, notify.NewWebhooks(cfg.Notify.Webhooks, links)...)
+	sender := notify.NewSender(cfg.Notify, repo.Outbox(), channels...)
+	senderDone := make(chan struct{})
+	go func() {
+		defer close(senderDone)
+		sender.Run(ctx)
+	}()
+	// the daily digest and reminders of stale requests, sent by one replica
+	scheduler := notify.NewScheduler(cfg.Notify, repo.Outbox(), repo.Workspaces(), emailSvc)
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		scheduler.Run(ctx)
+	}()
+
+	// changes made on other replicas
+	listenDone := make(chan struct{})
+	go func() {
+		defer close(listenDone)
+		repo.ListenWorkspaces(ctx, queue.Enqueue)
+	}()
 
-	e := echo.New()
-	controller.AddRoutes(e, cfg.Controller, queue, authSvc, repo.Workspaces(), repo.MailingList(), emailSvc)
+	stor := controller.NewSessionStore(cfg.Controller)
+
+	// re-read secrets on SIGHUP, eg. after rotating *_FILE secrets. Secrets
+	// built into workers and webhooks at startup are not swapped in.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-ctx.Done():
+				return
+			}
+
+			newCfg, err := config.Load(configFile)
+			if err != nil {
+				log.Println("reloading secrets:", err)
+				continue
+			}
+			authSvc.SetClientSecret(newCfg.Auth.ClientSecret)
+			emailSvc.SetPassword(newCfg.Email.Password)
+			stor.SetKeys(newCfg.Controller)
+			if err := repo.SetConnString(newCfg.Postgres.ConnString); err != nil {
+				log.Println("reloading secrets: postgres:", err)
+				continue
+			}
+			log.Println("reloaded the OIDC client secret, SMTP password, session keys, and Postgres credentials;",
+				"stage secret_file, notify webhook url_file, and kubeconfig client_secret_file need a restart")
+		}
+	}()
+
+	controller.AddRoutes(e, cfg.Controller, stor, policy, queue, &cfg.Cluster, authSvc, repo.Sessions(), repo.Users(), repo.Workspaces(), repo.MailingList(), repo.Outbox(), emailSvc)
 
 	startErrCh := make(chan error, 1)
 	go func() {
@@ -79,6 +203,9 @@ func run(ctx context.Context) error {
 	shutErr := e.Shutdown(context.Background())
 	startErr := <-startErrCh
 	queueErr := <-queueErrCh
+	<-listenDone
+	<-senderDone
+	<-schedulerDone
 
 	return errors.Join(shutErr, startErr, queueErr)
 }
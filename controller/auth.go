@@ -1,42 +1,71 @@
 package controller
 
 import (
+	"crypto/rand"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 
 	"github.com/bacchus-snu/sgs/model"
 	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/pkg/email"
 	"github.com/bacchus-snu/sgs/view"
 )
 
-// middlewareAuth adds the user to the context if they are authenticated.
-func middlewareAuth() echo.MiddlewareFunc {
+// middlewareAuth adds the user and their session to the context if they are
+// authenticated, and the policy deciding what they may do. While an admin
+// impersonates someone, the user is the impersonated one, and the admin is
+// the "impersonator".
+func middlewareAuth(
+	cfg Config,
+	policy *auth.Policy,
+	authSvc auth.Service,
+	sessSvc model.SessionService,
+	userSvc model.UserService,
+) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if strings.HasPrefix(c.Request().URL.Path, "/static/") {
+				// public, and requested many at once
+				return next(c)
+			}
+
+			c.Set("policy", policy)
 			sess, _ := session.Get("session", c)
-			if user, ok := sess.Values["user"].(*auth.User); ok {
-				c.Set("user", user)
+			if token, ok := sess.Values["sid"].(string); ok {
+				loginSess, err := loadSession(c, cfg, authSvc, sessSvc, userSvc, token)
+				if err != nil {
+					return err
+				}
+				if loginSess != nil {
+					c.Set("session", loginSess)
+					user := sessionUser(loginSess)
+					if imp := loginSess.Impersonating; imp != nil {
+						// everything is decided as the impersonated user
+						c.Set("impersonator", user)
+						user = &auth.User{Username: imp.Username, Email: imp.Email, Groups: imp.Groups}
+					}
+					c.Set("user", user)
+				}
 			}
 			return next(c)
 		}
 	}
 }
 
-// middlewareSubscriptionStatus checks if the logged-in admin is subscribed to the mailing list.
-func middlewareSubscriptionStatus(mlSvc model.MailingListService) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			user, _ := c.Get("user").(*auth.User)
-			if user != nil && user.IsAdmin() {
-				isSubscribed, _ := mlSvc.IsSubscribed(c.Request().Context(), user.Username)
-				c.Set("isSubscribed", isSubscribed)
-			}
-			return next(c)
-		}
-	}
+// can reports whether the current user may perform act on ws, see
+// auth.Policy.Can. All authorization goes through here.
+func can(c echo.Context, act auth.Action, ws *model.Workspace) bool {
+	user, _ := c.Get("user").(*auth.User)
+	policy, _ := c.Get("policy").(*auth.Policy)
+	return policy != nil && policy.Can(user, act, ws)
 }
 
 // middlewareAuthenticated redirects to the auth route if the user is not authenticated.
@@ -99,14 +128,18 @@ func handleAuth(
 }
 
 func handleAuthCallback(
+	cfg Config,
 	authSvc auth.Service,
+	sessSvc model.SessionService,
+	userSvc model.UserService,
 ) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		sess, _ := session.Get("session", c)
 		verifier := sess.Values["auth_verifier"]
 
-		user, err := authSvc.Exchange(
-			c.Request().Context(),
+		user, tokens, err := authSvc.Exchange(
+			ctx,
 			c.QueryParam("code"),
 			c.QueryParam("state"),
 			verifier,
@@ -115,21 +148,145 @@ func handleAuthCallback(
 			return err
 		}
 
-		sess.Values["user"] = user
+		// a good time to clean up
+		now := time.Now()
+		err = sessSvc.DeleteExpiredSessions(ctx, now.Add(-cfg.SessionIdleTimeout), now.Add(-cfg.SessionAbsoluteTimeout))
+		if err != nil {
+			slog.Error("failed to delete expired sessions", "error", err)
+		}
+
+		token := rand.Text()
+		_, err = sessSvc.CreateSession(ctx, &model.Session{
+			Subject:           user.Subject,
+			Username:          user.Username,
+			Email:             user.Email,
+			Groups:            user.Groups,
+			RefreshToken:      tokens.RefreshToken,
+			IDToken:           tokens.IDToken,
+			ProviderSessionID: tokens.SessionID,
+			UserAgent:         c.Request().UserAgent(),
+			RemoteIP:          c.RealIP(),
+		}, token)
+		if err != nil {
+			return err
+		}
+
+		// emails are sent in the language of the browser last logged in with
+		lang := email.MatchLanguage(c.Request().Header.Get("Accept-Language"))
+		if err := userSvc.SetLanguage(ctx, user.Username, lang); err != nil {
+			slog.Error("failed to set preferred language", "username", user.Username, "error", err)
+		}
+		// for notifications before accepting an invitation
+		if user.Email != "" {
+			if err := userSvc.SetEmail(ctx, user.Username, user.Email); err != nil {
+				slog.Error("failed to set email", "username", user.Username, "error", err)
+			}
+		}
+		// subscribed admins are only emailed while their groups allow it
+		if err := userSvc.SetGroups(ctx, user.Username, user.Groups); err != nil {
+			slog.Error("failed to set groups", "username", user.Username, "error", err)
+		}
+
+		delete(sess.Values, "auth_verifier")
+		sess.Values["sid"] = token
 		sess.Save(c.Request(), c.Response())
 		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("auth"))
 	}
 }
 
-func handleAuthLogout() echo.HandlerFunc {
+func handleAuthLogout(
+	authSvc auth.Service,
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		sess, _ := session.Get("session", c)
-		delete(sess.Values, "user")
-		sess.Save(c.Request(), c.Response())
+		loginSess, ok := c.Get("session").(*model.Session)
+		if !ok {
+			return logout(c)
+		}
 
-		// Clear user from context so header doesn't show logged in state
-		c.Set("user", nil)
+		err := sessSvc.DeleteSession(c.Request().Context(), loginSess.ID)
+		if err != nil && !errors.Is(err, model.ErrNotFound) {
+			return err
+		}
 
-		return c.Render(http.StatusOK, "", view.PageLogout())
+		// log out of the provider too, or the next login is automatic
+		if logoutURL, ok := authSvc.LogoutURL(loginSess.IDToken); ok {
+			forgetSession(c)
+			return c.Redirect(http.StatusSeeOther, logoutURL)
+		}
+		return logout(c)
+	}
+}
+
+// handleBackchannelLogout revokes the sessions of a user who logged out of
+// the provider, see OpenID Connect Back-Channel Logout 1.0.
+func handleBackchannelLogout(
+	authSvc auth.Service,
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "no-store")
+
+		token, err := authSvc.VerifyLogoutToken(c.Request().Context(), c.FormValue("logout_token"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":             "invalid_request",
+				"error_description": err.Error(),
+			})
+		}
+
+		err = sessSvc.DeleteProviderSessions(c.Request().Context(), token.Subject, token.SessionID)
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// logout forgets the session of the user, which must already be revoked.
+func logout(c echo.Context) error {
+	forgetSession(c)
+
+	// Clear user from context so header doesn't show logged in state
+	c.Set("user", nil)
+	c.Set("session", nil)
+
+	return c.Render(http.StatusOK, "", view.PageLogout())
+}
+
+func forgetSession(c echo.Context) {
+	sess, _ := session.Get("session", c)
+	delete(sess.Values, "sid")
+	sess.Save(c.Request(), c.Response())
+}
+
+func handleDevLoginForm() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "", view.PageDevLogin(c.QueryParam("state")))
+	}
+}
+
+// handleDevLogin logs in the submitted user, continuing at the callback as if
+// the provider had authenticated them.
+func handleDevLogin(
+	authSvc *auth.DevService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := &auth.User{
+			Username: c.FormValue("username"),
+			Email:    c.FormValue("email"),
+			Groups: strings.FieldsFunc(c.FormValue("groups"), func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			}),
+		}
+		if user.Username == "" {
+			return model.ErrInvalid
+		}
+
+		q := url.Values{
+			"code":  {authSvc.Code(user)},
+			"state": {c.FormValue("state")},
+		}
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("auth-callback")+"?"+q.Encode())
 	}
 }
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+)
+
+// Impersonations stop on their own after this long.
+const impersonationTimeout = time.Hour
+
+// Number of audit log entries shown on the sessions page.
+const impersonationLogLimit = 50
+
+// middlewareImpersonationReadOnly rejects all requests that may change
+// anything while impersonating, except stopping it.
+func middlewareImpersonationReadOnly() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Get("impersonator") == nil {
+				return next(c)
+			}
+
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if c.Path() == c.Echo().Reverse("impersonate-stop") {
+				return next(c)
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "Read-only while impersonating")
+		}
+	}
+}
+
+func handleStartImpersonation(
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !can(c, auth.ActionImpersonate, nil) {
+			return echo.ErrForbidden
+		}
+		admin := c.Get("user").(*auth.User)
+		loginSess := c.Get("session").(*model.Session)
+
+		username := c.FormValue("username")
+		if username == "" || username == admin.Username {
+			return model.ErrInvalid
+		}
+
+		// the user as of their latest login, or only the username if they
+		// never logged in
+		imp := &model.Impersonation{Username: username, StartedAt: time.Now()}
+		sesss, err := sessSvc.ListUserSessions(c.Request().Context(), username)
+		if err != nil {
+			return err
+		}
+		if len(sesss) > 0 {
+			imp.Email, imp.Groups = sesss[0].Email, sesss[0].Groups
+		}
+
+		if err := sessSvc.StartImpersonation(c.Request().Context(), loginSess.ID, imp); err != nil {
+			return err
+		}
+		slog.Info("impersonation started", "admin", admin.Username, "username", username)
+
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
+	}
+}
+
+func handleStopImpersonation(
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		admin, ok := c.Get("impersonator").(*auth.User)
+		if !ok {
+			return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
+		}
+		user := c.Get("user").(*auth.User)
+		loginSess := c.Get("session").(*model.Session)
+
+		if err := sessSvc.StopImpersonation(c.Request().Context(), loginSess.ID); err != nil {
+			return err
+		}
+		slog.Info("impersonation stopped", "admin", admin.Username, "username", user.Username)
+
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
+	}
+}
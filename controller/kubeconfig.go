@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"text/template"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
+)
+
+//go:embed kubeconfig.template
+var kubeconfigTemplate string
+
+var tmplKubeconfig = template.Must(template.New("kubeconfig").
+	Funcs(template.FuncMap{"quote": quoteYAML}).
+	Parse(kubeconfigTemplate))
+
+// quoteYAML quotes s as a YAML string. JSON strings are valid YAML.
+func quoteYAML(s string) (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+type kubeconfigContext struct {
+	Name      string
+	Cluster   string
+	Namespace string
+}
+
+type kubeconfigData struct {
+	// where to download the kubeconfig again
+	URL            string
+	Clusters       []cluster.Cluster
+	OIDC           cluster.OIDC
+	Contexts       []kubeconfigContext
+	CurrentContext string
+}
+
+// renderKubeconfig writes a kubeconfig with one context per workspace, in the
+// cluster the workspace is placed in. The first workspace is the current
+// context. It is downloaded from url.
+func renderKubeconfig(w io.Writer, url string, clusters *cluster.Config, wss []*model.Workspace) error {
+	data := kubeconfigData{URL: url, OIDC: clusters.OIDC}
+	for _, ws := range wss {
+		name := clusters.Place(ws)
+		cl, ok := clusters.Get(name)
+		if !ok || cl.Server == "" {
+			return fmt.Errorf("cluster %q of workspace %s is not configured", name, ws.ID.Hash())
+		}
+		if !slices.ContainsFunc(data.Clusters, func(c cluster.Cluster) bool { return c.Name == name }) {
+			data.Clusters = append(data.Clusters, cl)
+		}
+		data.Contexts = append(data.Contexts, kubeconfigContext{
+			Name:      name + "-" + ws.ID.Hash(),
+			Cluster:   name,
+			Namespace: "ws-" + ws.ID.Hash(),
+		})
+	}
+	if len(data.Contexts) == 0 {
+		return errors.New("no workspaces")
+	}
+	data.CurrentContext = data.Contexts[0].Name
+
+	return tmplKubeconfig.Execute(w, data)
+}
+
+// sendKubeconfig responds with the kubeconfig of wss, downloaded from url, as
+// a download.
+func sendKubeconfig(c echo.Context, url string, clusters *cluster.Config, filename string, wss []*model.Workspace) error {
+	// only created workspaces have a namespace
+	wss = slices.DeleteFunc(wss, func(ws *model.Workspace) bool { return !ws.Created })
+	if len(wss) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "No provisioned workspaces")
+	}
+
+	var buf bytes.Buffer
+	if err := renderKubeconfig(&buf, url, clusters, wss); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Kubeconfig is not available").SetInternal(err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, "application/yaml", buf.Bytes())
+}
+
+func handleWorkspaceKubeconfig(
+	clusters *cluster.Config,
+	links *Links,
+	wsSvc model.WorkspaceService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ws, err := getWorkspace(c, wsSvc)
+		if err != nil {
+			return err
+		}
+
+		url := links.URL("workspace-kubeconfig", ws.ID.Hash())
+		return sendKubeconfig(c, url, clusters, "kubeconfig-"+ws.ID.Hash()+".yaml", []*model.Workspace{ws})
+	}
+}
+
+// handleKubeconfig serves a kubeconfig with all workspaces the user has
+// accepted, including admins.
+func handleKubeconfig(
+	clusters *cluster.Config,
+	links *Links,
+	wsSvc model.WorkspaceService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+
+		wss, err := wsSvc.ListUserWorkspaces(c.Request().Context(), user.Username)
+		if err != nil {
+			return err
+		}
+
+		return sendKubeconfig(c, links.URL("kubeconfig"), clusters, "kubeconfig.yaml", wss)
+	}
+}
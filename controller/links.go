@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// Links builds absolute URLs of the routes of e, for links leaving the site,
+// such as in emails and kubeconfigs. Routes are reversed when a link is built,
+// so Links may be created before they are added.
+type Links struct {
+	e    *echo.Echo
+	base string
+	docs string
+}
+
+func NewLinks(cfg Config, e *echo.Echo) *Links {
+	return &Links{e: e, base: cfg.BaseURL, docs: cfg.DocsURL}
+}
+
+// URL returns the absolute URL of the named route, see echo.Echo.Reverse.
+func (l *Links) URL(name string, params ...any) string {
+	return l.base + l.e.Reverse(name, params...)
+}
+
+// WorkspaceURL returns the URL of the workspace's page.
+func (l *Links) WorkspaceURL(id model.ID) string {
+	return l.URL("workspace-details", id.Hash())
+}
+
+// DocsURL returns the URL of the user documentation.
+func (l *Links) DocsURL() string {
+	return l.docs
+}
+
+// middlewareDocsURL passes the documentation URL to views.
+func middlewareDocsURL(links *Links) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("docsURL", links.DocsURL())
+			return next(c)
+		}
+	}
+}
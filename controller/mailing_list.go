@@ -1,40 +1,102 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/bacchus-snu/sgs/model"
 	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/view"
 )
 
-func handleSubscribe(mlSvc model.MailingListService) echo.HandlerFunc {
+// subscribableNodegroups returns the nodegroups the current user may view the
+// workspaces of, and so subscribe to.
+func subscribableNodegroups(c echo.Context) []model.Nodegroup {
+	var ngs []model.Nodegroup
+	for _, ng := range model.Nodegroups {
+		if can(c, auth.ActionView, &model.Workspace{Nodegroup: ng}) {
+			ngs = append(ngs, ng)
+		}
+	}
+	return ngs
+}
+
+func handleSubscriptionSettings(mlSvc model.MailingListService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user := c.Get("user").(*auth.User)
-		if !user.IsAdmin() {
+		if !can(c, auth.ActionSubscribe, nil) {
 			return echo.ErrForbidden
 		}
 
-		if err := mlSvc.Subscribe(c.Request().Context(), user.Username, user.Email); err != nil {
+		sub, err := mlSvc.GetSubscription(c.Request().Context(), user.Username)
+		if err != nil && !errors.Is(err, model.ErrNotFound) {
 			return err
 		}
 
-		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
+		return c.Render(http.StatusOK, "", view.PageSubscription(sub, subscribableNodegroups(c)))
 	}
 }
 
-func handleUnsubscribe(mlSvc model.MailingListService) echo.HandlerFunc {
+func handleUpdateSubscription(mlSvc model.MailingListService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user := c.Get("user").(*auth.User)
-		if !user.IsAdmin() {
+		if !can(c, auth.ActionSubscribe, nil) {
 			return echo.ErrForbidden
 		}
 
-		if err := mlSvc.Unsubscribe(c.Request().Context(), user.Username); err != nil {
+		ctx := c.Request().Context()
+		mode := c.FormValue("mode")
+		if mode == "" {
+			if err := mlSvc.Unsubscribe(ctx, user.Username); err != nil {
+				return err
+			}
+			return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("subscription-settings"))
+		}
+
+		form, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		sub := &model.Subscriber{
+			Username: user.Username,
+			Email:    user.Email,
+			Mode:     model.SubscriptionMode(mode),
+		}
+		for _, ng := range form["nodegroups"] {
+			sub.Nodegroups = append(sub.Nodegroups, model.Nodegroup(ng))
+		}
+		for _, event := range form["events"] {
+			sub.Events = append(sub.Events, model.NotificationEvent(event))
+		}
+		if !sub.Valid() {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid subscription")
+		}
+		sub.Nodegroups = slices.Compact(slices.Sorted(slices.Values(sub.Nodegroups)))
+		sub.Events = slices.Compact(slices.Sorted(slices.Values(sub.Events)))
+		if len(sub.Nodegroups) == 0 || len(sub.Events) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Choose at least one nodegroup and event")
+		}
+		allowed := subscribableNodegroups(c)
+		for _, ng := range sub.Nodegroups {
+			if !slices.Contains(allowed, ng) {
+				return echo.ErrForbidden
+			}
+		}
+		// all of them includes those added later
+		if len(sub.Nodegroups) == len(model.Nodegroups) {
+			sub.Nodegroups = nil
+		}
+		if len(sub.Events) == len(model.AdminEvents) {
+			sub.Events = nil
+		}
+
+		if err := mlSvc.Subscribe(ctx, sub); err != nil {
 			return err
 		}
 
-		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("subscription-settings"))
 	}
 }
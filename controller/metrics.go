@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"expvar"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/pkg/auth"
+)
+
+// handleMetrics serves the expvar metrics, such as those of the worker.
+func handleMetrics() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !can(c, auth.ActionMetrics, nil) {
+			return echo.ErrForbidden
+		}
+
+		expvar.Handler().ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
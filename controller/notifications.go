@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/pkg/email"
+	"github.com/bacchus-snu/sgs/view"
+)
+
+func handleNotificationSettings(
+	userSvc model.UserService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+
+		prefs, err := userSvc.NotificationPreferences(c.Request().Context(), []string{user.Username})
+		if err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "", view.PageNotificationSettings(prefs[user.Username]))
+	}
+}
+
+// Links confirming notification addresses expire after emailConfirmationTimeout.
+const emailConfirmationTimeout = 24 * time.Hour
+
+func handleUpdateNotificationSettings(
+	links *Links,
+	userSvc model.UserService,
+	emailSvc email.Service,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+		ctx := c.Request().Context()
+
+		current, err := userSvc.NotificationPreferences(ctx, []string{user.Username})
+		if err != nil {
+			return err
+		}
+		var confirmed string
+		if p := current[user.Username]; p != nil {
+			confirmed = p.Email
+		}
+
+		addr := strings.TrimSpace(c.FormValue("email"))
+		if addr != "" {
+			// a bare address, without a display name
+			parsed, err := mail.ParseAddress(addr)
+			if err != nil || parsed.Address != addr {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid email address")
+			}
+		}
+		prefs := &model.NotificationPreferences{
+			Email:   addr,
+			Enabled: make(map[model.NotificationEvent]map[model.NotificationChannel]bool),
+		}
+		if addr != "" && addr != confirmed {
+			// anyone may be entered, so new addresses are only used once
+			// their owner confirms them
+			prefs.Email = confirmed
+			token := rand.Text()
+			if err := userSvc.RequestNotificationEmail(ctx, user.Username, addr, token, time.Now().Add(emailConfirmationTimeout)); err != nil {
+				return err
+			}
+			if err := emailSvc.ConfirmAddress(ctx, user.Username, addr, links.URL("notification-email-confirm", token)); err != nil {
+				return err
+			}
+		}
+		// unchecked boxes are not submitted
+		for _, event := range model.UserEvents {
+			prefs.Enabled[event] = make(map[model.NotificationChannel]bool)
+			for _, ch := range model.NotificationChannels {
+				prefs.Enabled[event][ch] = c.FormValue(view.NotificationField(event, ch)) == "on"
+			}
+		}
+
+		if err := userSvc.SetNotificationPreferences(ctx, user.Username, prefs); err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("notification-settings"))
+	}
+}
+
+func handleConfirmNotificationEmail(
+	userSvc model.UserService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+		if c.Get("impersonator") != nil {
+			// a GET, so not refused by middlewareImpersonationReadOnly
+			return echo.NewHTTPError(http.StatusForbidden, "Read-only while impersonating")
+		}
+
+		_, err := userSvc.ConfirmNotificationEmail(c.Request().Context(), user.Username, c.Param("token"), time.Now())
+		if errors.Is(err, model.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid or expired confirmation link")
+		} else if err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("notification-settings"))
+	}
+}
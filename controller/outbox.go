@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/view"
+)
+
+// delivered notifications shown in the outbox, besides undelivered ones
+const outboxSentLimit = 50
+
+func handleListOutbox(
+	outboxSvc model.OutboxService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !can(c, auth.ActionOutbox, nil) {
+			return echo.ErrForbidden
+		}
+
+		ns, err := outboxSvc.ListNotifications(c.Request().Context(), outboxSentLimit)
+		if err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "", view.PageOutbox(ns))
+	}
+}
+
+func handleRetryNotification(
+	outboxSvc model.OutboxService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !can(c, auth.ActionOutbox, nil) {
+			return echo.ErrForbidden
+		}
+
+		id, err := model.ParseID(c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if err := outboxSvc.RetryNotification(c.Request().Context(), id); err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("outbox"))
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -17,51 +20,141 @@ import (
 
 	"github.com/bacchus-snu/sgs/model"
 	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
 	"github.com/bacchus-snu/sgs/pkg/email"
+	"github.com/bacchus-snu/sgs/pkg/secret"
 	"github.com/bacchus-snu/sgs/view"
 	"github.com/bacchus-snu/sgs/worker"
 )
 
 type Config struct {
-	SessionKey string `mapstructure:"session_key"`
-	sessionKey []byte
+	SessionKey     string `mapstructure:"session_key"`
+	SessionKeyFile string `mapstructure:"session_key_file"`
+	// Sessions signed with previous keys are still accepted, so the session
+	// key can be rotated without logging everyone out.
+	PreviousSessionKeys     []string `mapstructure:"previous_session_keys"`
+	PreviousSessionKeysFile string   `mapstructure:"previous_session_keys_file"`
+
+	// Sessions expire when unused for SessionIdleTimeout, and at the latest
+	// SessionAbsoluteTimeout after logging in.
+	SessionIdleTimeout     time.Duration `mapstructure:"session_idle_timeout"`
+	SessionAbsoluteTimeout time.Duration `mapstructure:"session_absolute_timeout"`
+	// The user's groups are re-validated with the OIDC provider this often.
+	SessionRefreshInterval time.Duration `mapstructure:"session_refresh_interval"`
+
+	// Public URL sgs is served at, eg. https://sgs.snucse.org, for links
+	// leaving the site: emails, kubeconfigs, ...
+	BaseURL string `mapstructure:"base_url"`
+	// User documentation, linked from the site and emails.
+	DocsURL string `mapstructure:"docs_url"`
+
+	sessionKeys [][]byte
 }
 
 func (c *Config) Bind() {
 	viper.BindEnv("controller.session_key", "SGS_SESSION_KEY")
+	viper.BindEnv("controller.session_key_file", "SGS_SESSION_KEY_FILE")
+	viper.BindEnv("controller.previous_session_keys", "SGS_PREVIOUS_SESSION_KEYS")
+	viper.BindEnv("controller.previous_session_keys_file", "SGS_PREVIOUS_SESSION_KEYS_FILE")
+	viper.BindEnv("controller.session_idle_timeout", "SGS_SESSION_IDLE_TIMEOUT")
+	viper.BindEnv("controller.session_absolute_timeout", "SGS_SESSION_ABSOLUTE_TIMEOUT")
+	viper.BindEnv("controller.session_refresh_interval", "SGS_SESSION_REFRESH_INTERVAL")
+	viper.BindEnv("controller.base_url", "SGS_BASE_URL")
+	viper.BindEnv("controller.docs_url", "SGS_DOCS_URL")
+
+	viper.SetDefault("controller.session_idle_timeout", 24*time.Hour)
+	viper.SetDefault("controller.session_absolute_timeout", 7*24*time.Hour)
+	viper.SetDefault("controller.session_refresh_interval", 15*time.Minute)
+	viper.SetDefault("controller.docs_url", "https://sgs-docs.snucse.org")
 }
 
 func (c *Config) Validate() error {
+	if err := secret.Load(&c.SessionKey, c.SessionKeyFile); err != nil {
+		return fmt.Errorf("session_key_file: %w", err)
+	}
 	if c.SessionKey == "" {
 		return errors.New("session_key is required")
 	}
+	if c.PreviousSessionKeysFile != "" {
+		// one key per line
+		s, err := secret.ReadFile(c.PreviousSessionKeysFile)
+		if err != nil {
+			return fmt.Errorf("previous_session_keys_file: %w", err)
+		}
+		c.PreviousSessionKeys = strings.Fields(s)
+	}
+
+	if c.SessionIdleTimeout <= 0 || c.SessionAbsoluteTimeout <= 0 || c.SessionRefreshInterval <= 0 {
+		return errors.New("session timeouts must be positive")
+	}
+
+	if c.BaseURL == "" {
+		return errors.New("base_url is required")
+	}
+	if !validBaseURL(c.BaseURL) {
+		return fmt.Errorf("invalid base_url %q", c.BaseURL)
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	if !validBaseURL(c.DocsURL) {
+		return fmt.Errorf("invalid docs_url %q", c.DocsURL)
+	}
 
-	d, err := hex.DecodeString(c.SessionKey)
-	if err != nil {
-		return fmt.Errorf("invalid session_key: %w", err)
+	c.sessionKeys = nil
+	for i, key := range append([]string{c.SessionKey}, c.PreviousSessionKeys...) {
+		d, err := hex.DecodeString(key)
+		if err != nil {
+			if i == 0 {
+				return fmt.Errorf("invalid session_key: %w", err)
+			}
+			return fmt.Errorf("invalid previous_session_keys[%d]: %w", i-1, err)
+		}
+		c.sessionKeys = append(c.sessionKeys, d)
 	}
-	c.sessionKey = d
 
 	return nil
 }
 
+// validBaseURL reports whether s is an absolute http(s) URL, that paths can
+// be appended to.
+func validBaseURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.RawQuery == "" && u.Fragment == ""
+}
+
+// Redacted returns a copy of c with secrets redacted.
+func (c Config) Redacted() Config {
+	if c.SessionKey != "" {
+		c.SessionKey = "REDACTED"
+	}
+	c.PreviousSessionKeys = slices.Clone(c.PreviousSessionKeys)
+	for i := range c.PreviousSessionKeys {
+		c.PreviousSessionKeys[i] = "REDACTED"
+	}
+	c.sessionKeys = nil
+	return c
+}
+
 func AddRoutes(
 	e *echo.Echo,
 	cfg Config,
-	queue worker.Queue,
+	stor *SessionStore,
+	policy *auth.Policy,
+	queue *worker.Queue,
+	clusters *cluster.Config,
 	authSvc auth.Service,
+	sessSvc model.SessionService,
+	userSvc model.UserService,
 	wsSvc model.WorkspaceService,
 	mlSvc model.MailingListService,
+	outboxSvc model.OutboxService,
 	emailSvc email.Service,
 ) {
-	stor := sessions.NewCookieStore(cfg.sessionKey)
-	stor.Options.SameSite = http.SameSiteLaxMode
-	stor.Options.Secure = true
-	stor.Options.HttpOnly = true
-
 	e.Renderer = view.Renderer
 	e.HTTPErrorHandler = view.ErrorHandler
 
+	links := NewLinks(cfg, e)
+
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 	e.Use(
 		// generic
@@ -96,6 +189,10 @@ func AddRoutes(
 
 		// csrf
 		middleware.CSRFWithConfig(middleware.CSRFConfig{
+			// called by the provider, authenticated by the logout token
+			Skipper: func(c echo.Context) bool {
+				return c.Path() == "/auth/backchannel-logout"
+			},
 			TokenLookup:    "form:_csrf",
 			ContextKey:     "csrf",
 			CookieSecure:   true,
@@ -107,32 +204,54 @@ func AddRoutes(
 		middleware.Gzip(),
 
 		session.Middleware(stor),
-		middlewareAuth(),
-		middlewareSubscriptionStatus(mlSvc),
+		middlewareAuth(cfg, policy, authSvc, sessSvc, userSvc),
+		middlewareImpersonationReadOnly(),
+		middlewareDocsURL(links),
 	)
 
 	e.GET("/healthz", func(c echo.Context) error {
-		return c.String(http.StatusOK, "OK")
+		// standby replicas are healthy too, only one of them runs the queue
+		if queue.IsLeader() {
+			return c.String(http.StatusOK, "OK (leader)")
+		}
+		return c.String(http.StatusOK, "OK (standby)")
 	})
 
 	e.StaticFS("/static", view.Static)
 
 	e.GET("/auth", handleAuth(authSvc)).Name = "auth"
-	e.GET("/auth/callback", handleAuthCallback(authSvc))
-	e.GET("/auth/logout", handleAuthLogout())
+	e.GET("/auth/callback", handleAuthCallback(cfg, authSvc, sessSvc, userSvc)).Name = "auth-callback"
+	if devSvc, ok := authSvc.(*auth.DevService); ok {
+		e.GET(auth.DevLoginPath, handleDevLoginForm())
+		e.POST(auth.DevLoginPath, handleDevLogin(devSvc))
+	}
+	e.GET("/auth/logout", handleAuthLogout(authSvc, sessSvc))
+	e.POST("/auth/backchannel-logout", handleBackchannelLogout(authSvc, sessSvc))
 
 	requireAuth := middlewareAuthenticated()
 
-	e.GET("/", handleListWorkspaces(wsSvc), requireAuth).Name = "workspace-list"
-	e.GET("/ws/:id", handleWorkspaceDetails(wsSvc), requireAuth).Name = "workspace-details"
-	e.POST("/ws/:id", handleUpdateWorkspace(queue, wsSvc, emailSvc), requireAuth)
+	e.GET("/sessions", handleListSessions(sessSvc), requireAuth).Name = "sessions"
+	e.POST("/sessions/:id/revoke", handleRevokeSession(sessSvc), requireAuth).Name = "session-revoke"
+	e.POST("/impersonate", handleStartImpersonation(sessSvc), requireAuth).Name = "impersonate"
+	e.POST("/impersonate/stop", handleStopImpersonation(sessSvc), requireAuth).Name = "impersonate-stop"
+	e.GET("/settings/notifications", handleNotificationSettings(userSvc), requireAuth).Name = "notification-settings"
+	e.POST("/settings/notifications", handleUpdateNotificationSettings(links, userSvc, emailSvc), requireAuth)
+	e.GET("/settings/notifications/confirm/:token", handleConfirmNotificationEmail(userSvc), requireAuth).Name = "notification-email-confirm"
+	e.GET("/settings/subscription", handleSubscriptionSettings(mlSvc), requireAuth).Name = "subscription-settings"
+	e.POST("/settings/subscription", handleUpdateSubscription(mlSvc), requireAuth)
+	e.GET("/outbox", handleListOutbox(outboxSvc), requireAuth).Name = "outbox"
+	e.POST("/outbox/:id/retry", handleRetryNotification(outboxSvc), requireAuth).Name = "outbox-retry"
+	e.GET("/debug/vars", handleMetrics(), requireAuth)
+
+	e.GET("/", handleListWorkspaces(queue, wsSvc), requireAuth).Name = "workspace-list"
+	e.GET("/ws/:id", handleWorkspaceDetails(clusters, wsSvc), requireAuth).Name = "workspace-details"
+	e.POST("/ws/:id", handleUpdateWorkspace(queue, clusters, wsSvc), requireAuth)
+	e.GET("/ws/:id/kubeconfig", handleWorkspaceKubeconfig(clusters, links, wsSvc), requireAuth).Name = "workspace-kubeconfig"
+	e.GET("/kubeconfig", handleKubeconfig(clusters, links, wsSvc), requireAuth).Name = "kubeconfig"
+	e.POST("/ws/:id/preview", handlePreviewWorkspace(queue, wsSvc), requireAuth).Name = "workspace-preview"
 	e.POST("/ws/:id/accept", handleAcceptInvitation(wsSvc), requireAuth).Name = "workspace-accept"
 	e.POST("/ws/:id/decline", handleDeclineInvitation(wsSvc), requireAuth).Name = "workspace-decline"
 
 	e.GET("/request", handleRequestWorkspaceForm(), requireAuth)
-	e.POST("/request", handleRequestWorkspace(wsSvc, mlSvc, emailSvc), requireAuth)
-
-	// Mailing list routes (admin only, but auth checked in handler)
-	e.POST("/mail/subscribe", handleSubscribe(mlSvc), requireAuth)
-	e.POST("/mail/unsubscribe", handleUnsubscribe(mlSvc), requireAuth)
+	e.POST("/request", handleRequestWorkspace(wsSvc), requireAuth)
 }
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/view"
+)
+
+// SessionStore stores cookie sessions in signed cookies. They hold the token
+// of the server-side model.Session of the user, and the state of logins in
+// progress. Its keys can be rotated without a restart.
+type SessionStore struct {
+	store atomic.Pointer[sessions.CookieStore]
+}
+
+var _ sessions.Store = (*SessionStore)(nil)
+
+func NewSessionStore(cfg Config) *SessionStore {
+	s := &SessionStore{}
+	s.SetKeys(cfg)
+	return s
+}
+
+// SetKeys replaces the session keys. New sessions are signed with the current
+// key, and sessions signed with previous keys remain valid.
+func (s *SessionStore) SetKeys(cfg Config) {
+	keyPairs := make([][]byte, 0, 2*len(cfg.sessionKeys))
+	for _, key := range cfg.sessionKeys {
+		// authentication only, no encryption
+		keyPairs = append(keyPairs, key, nil)
+	}
+
+	stor := sessions.NewCookieStore(keyPairs...)
+	stor.Options.SameSite = http.SameSiteLaxMode
+	stor.Options.Secure = true
+	stor.Options.HttpOnly = true
+	// the session itself expires server-side
+	stor.Options.MaxAge = int(cfg.SessionAbsoluteTimeout.Seconds())
+	s.store.Store(stor)
+}
+
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.store.Load().New(r, name)
+}
+
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	return s.store.Load().Save(r, w, sess)
+}
+
+// Sessions are only touched this often, so requests don't all write to the
+// db.
+const sessionTouchInterval = time.Minute
+
+func sessionUser(sess *model.Session) *auth.User {
+	return &auth.User{
+		Username: sess.Username,
+		Email:    sess.Email,
+		Groups:   sess.Groups,
+	}
+}
+
+// loadSession returns the valid session identified by token, or nil if there
+// is none. Expired sessions are revoked, and the user is re-validated with the
+// OIDC provider every SessionRefreshInterval. Impersonations are stopped after
+// impersonationTimeout.
+func loadSession(
+	c echo.Context,
+	cfg Config,
+	authSvc auth.Service,
+	sessSvc model.SessionService,
+	userSvc model.UserService,
+	token string,
+) (*model.Session, error) {
+	ctx := c.Request().Context()
+	sess, err := sessSvc.GetSession(ctx, token)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Sub(sess.LastSeenAt) > cfg.SessionIdleTimeout || now.Sub(sess.CreatedAt) > cfg.SessionAbsoluteTimeout {
+		if err := sessSvc.DeleteSession(ctx, sess.ID); err != nil && !errors.Is(err, model.ErrNotFound) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if sess.Impersonating != nil && now.Sub(sess.Impersonating.StartedAt) > impersonationTimeout {
+		if err := sessSvc.StopImpersonation(ctx, sess.ID); err != nil {
+			return nil, err
+		}
+		sess.Impersonating = nil
+	}
+
+	if sess.RefreshToken != "" && now.Sub(sess.ValidatedAt) > cfg.SessionRefreshInterval {
+		// Providers rotating refresh tokens reject all but their first use,
+		// so only the request claiming the refresh uses it. Concurrent ones
+		// go on with the session as is, and later ones see the result.
+		ok, err := sessSvc.ClaimRefresh(ctx, sess.ID, sess.ValidatedAt, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			sess, err = refreshSession(c, authSvc, sessSvc, userSvc, sess, now)
+			if err != nil || sess == nil {
+				return nil, err
+			}
+		}
+	}
+
+	if now.Sub(sess.LastSeenAt) > sessionTouchInterval {
+		if err := sessSvc.TouchSession(ctx, sess.ID, now); err != nil {
+			return nil, err
+		}
+		sess.LastSeenAt = now
+	}
+
+	return sess, nil
+}
+
+// refreshSession re-validates the user of sess with the OIDC provider. Returns
+// nil if the provider revoked it. Other failures are retried at the next
+// refresh, so an unreachable provider is not asked on every request.
+func refreshSession(
+	c echo.Context,
+	authSvc auth.Service,
+	sessSvc model.SessionService,
+	userSvc model.UserService,
+	sess *model.Session,
+	now time.Time,
+) (*model.Session, error) {
+	ctx := c.Request().Context()
+	user, tokens, err := authSvc.Refresh(ctx, sess.RefreshToken)
+	switch {
+	case errors.Is(err, auth.ErrInvalidGrant):
+		// no longer valid at the provider, log in again
+		if err := sessSvc.DeleteSession(ctx, sess.ID); err != nil && !errors.Is(err, model.ErrNotFound) {
+			return nil, err
+		}
+		return nil, nil
+	case err != nil:
+		// keep the session until the provider is reachable again
+		slog.Error("failed to refresh session", "username", sess.Username, "error", err)
+	default:
+		sess.Subject, sess.Username, sess.Email, sess.Groups = user.Subject, user.Username, user.Email, user.Groups
+		sess.RefreshToken = tokens.RefreshToken
+		if tokens.IDToken != "" {
+			sess.IDToken, sess.ProviderSessionID = tokens.IDToken, tokens.SessionID
+		}
+		if err := sessSvc.ValidateSession(ctx, sess, now); err != nil {
+			return nil, err
+		}
+		if err := userSvc.SetGroups(ctx, sess.Username, sess.Groups); err != nil {
+			slog.Error("failed to set groups", "username", sess.Username, "error", err)
+		}
+	}
+	sess.ValidatedAt = now
+	return sess, nil
+}
+
+func handleListSessions(
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+		current, _ := c.Get("session").(*model.Session)
+
+		own, err := sessSvc.ListUserSessions(c.Request().Context(), user.Username)
+		if err != nil {
+			return err
+		}
+
+		var others []*model.Session
+		if can(c, auth.ActionSessions, nil) {
+			all, err := sessSvc.ListAllSessions(c.Request().Context())
+			if err != nil {
+				return err
+			}
+			for _, sess := range all {
+				if sess.Username != user.Username {
+					others = append(others, sess)
+				}
+			}
+		}
+
+		var events []model.ImpersonationEvent
+		if can(c, auth.ActionImpersonate, nil) {
+			events, err = sessSvc.ListImpersonationEvents(c.Request().Context(), impersonationLogLimit)
+			if err != nil {
+				return err
+			}
+		}
+
+		return c.Render(http.StatusOK, "", view.PageSessions(own, others, events, current))
+	}
+}
+
+func handleRevokeSession(
+	sessSvc model.SessionService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*auth.User)
+		current, _ := c.Get("session").(*model.Session)
+
+		id, err := model.ParseID(c.Param("id"))
+		if err != nil {
+			return err
+		}
+
+		if !can(c, auth.ActionSessions, nil) {
+			own, err := sessSvc.ListUserSessions(c.Request().Context(), user.Username)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(own, func(sess *model.Session) bool { return sess.ID == id }) {
+				return model.ErrNotFound
+			}
+		}
+
+		if err := sessSvc.DeleteSession(c.Request().Context(), id); err != nil {
+			return err
+		}
+
+		if current != nil && current.ID == id {
+			return logout(c)
+		}
+		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("sessions"))
+	}
+}
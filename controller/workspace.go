@@ -1,21 +1,24 @@
 package controller
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/bacchus-snu/sgs/model"
 	"github.com/bacchus-snu/sgs/pkg/auth"
-	"github.com/bacchus-snu/sgs/pkg/email"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
 	"github.com/bacchus-snu/sgs/view"
 	"github.com/bacchus-snu/sgs/worker"
 )
 
 func handleListWorkspaces(
+	queue *worker.Queue,
 	wsSvc model.WorkspaceService,
 ) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -24,8 +27,12 @@ func handleListWorkspaces(
 		var wss []*model.Workspace
 		var invitations []*model.Workspace
 		var err error
-		if user.IsAdmin() {
+		admin := can(c, auth.ActionView, nil)
+		if admin {
 			wss, err = wsSvc.ListAllWorkspaces(c.Request().Context())
+			wss = slices.DeleteFunc(wss, func(ws *model.Workspace) bool {
+				return !can(c, auth.ActionView, ws)
+			})
 		} else {
 			wss, err = wsSvc.ListUserWorkspaces(c.Request().Context(), user.Username)
 		}
@@ -34,39 +41,53 @@ func handleListWorkspaces(
 		}
 
 		// Fetch pending invitations for non-admin users
-		if !user.IsAdmin() {
+		if !admin {
 			invitations, err = wsSvc.ListUserInvitations(c.Request().Context(), user.Username)
 			if err != nil {
 				return err
 			}
 		}
 
-		return c.Render(http.StatusOK, "", view.PageWorkspaceList(wss, invitations))
+		// Cluster sync status, for admins only
+		var statuses []worker.ClusterStatus
+		if admin {
+			statuses = queue.ClusterStatuses()
+		}
+
+		return c.Render(http.StatusOK, "", view.PageWorkspaceList(wss, invitations, statuses))
 	}
 }
 
 func handleWorkspaceDetails(
+	clusters *cluster.Config,
 	wsSvc model.WorkspaceService,
 ) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		id, err := model.ParseID(c.Param("id"))
-		if err != nil {
-			return echo.ErrNotFound
-		}
-		user := c.Get("user").(*auth.User)
-
-		var ws *model.Workspace
-		if user.IsAdmin() {
-			ws, err = wsSvc.GetWorkspace(c.Request().Context(), id)
-		} else {
-			ws, err = wsSvc.GetUserWorkspace(c.Request().Context(), id, user.Username)
-		}
+		ws, err := getWorkspace(c, wsSvc)
 		if err != nil {
 			return err
 		}
 
-		return c.Render(http.StatusOK, "", view.PageWorkspaceDetails(ws))
+		return c.Render(http.StatusOK, "", view.PageWorkspaceDetails(ws, clusters))
+	}
+}
+
+// getWorkspace returns the workspace of the :id parameter, if the user may
+// view it.
+func getWorkspace(c echo.Context, wsSvc model.WorkspaceService) (*model.Workspace, error) {
+	id, err := model.ParseID(c.Param("id"))
+	if err != nil {
+		return nil, echo.ErrNotFound
+	}
+	ws, err := wsSvc.GetWorkspace(c.Request().Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if !can(c, auth.ActionView, ws) {
+		// don't reveal that the workspace exists
+		return nil, echo.ErrNotFound
 	}
+	return ws, nil
 }
 
 func handleRequestWorkspaceForm() echo.HandlerFunc {
@@ -77,21 +98,17 @@ func handleRequestWorkspaceForm() echo.HandlerFunc {
 
 // Check whether the user is allowed to request a workspace in the given
 // nodegroup.
-func checkNodegroups(user *auth.User, nodegroup string) error {
+func checkNodegroups(c echo.Context, nodegroup string) error {
 	if !model.Nodegroup(nodegroup).Valid() {
 		return echo.ErrBadRequest
 	}
-	if slices.Contains(user.Groups, nodegroup) {
+	if can(c, auth.ActionRequest, &model.Workspace{Nodegroup: model.Nodegroup(nodegroup)}) {
 		return nil
 	}
 	return echo.ErrForbidden
 }
 
-func handleRequestWorkspace(
-	wsSvc model.WorkspaceService,
-	mlSvc model.MailingListService,
-	emailSvc email.Service,
-) echo.HandlerFunc {
+func handleRequestWorkspace(wsSvc model.WorkspaceService) echo.HandlerFunc {
 	type formData struct {
 		Nodegroup          string `form:"nodegroup"`
 		Userdata           string `form:"userdata"`
@@ -130,34 +147,24 @@ func handleRequestWorkspace(
 			return echo.ErrBadRequest
 		}
 
-		if err := checkNodegroups(user, req.Nodegroup); err != nil {
+		if err := checkNodegroups(c, req.Nodegroup); err != nil {
 			return err
 		}
 
+		// subscribed admins are notified through the outbox
 		newWS, err := wsSvc.CreateWorkspace(c.Request().Context(), &ws, user.Email)
 		if err != nil {
 			return err
 		}
 
-		// Notify subscribed admins about the new workspace request
-		ctx := c.Request().Context()
-		subscribers, err := mlSvc.ListSubscribers(ctx)
-		if err != nil {
-			slog.Error("failed to list subscribers for notification", "error", err)
-		} else if len(subscribers) > 0 {
-			if err := emailSvc.SendWorkspaceRequestNotification(ctx, newWS, subscribers); err != nil {
-				slog.Error("failed to send workspace request notification", "error", err)
-			}
-		}
-
 		return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-details", newWS.ID.Hash()))
 	}
 }
 
 func handleUpdateWorkspace(
-	queue worker.Queue,
+	queue *worker.Queue,
+	clusters *cluster.Config,
 	wsSvc model.WorkspaceService,
-	emailSvc email.Service,
 ) echo.HandlerFunc {
 	type formData struct {
 		Enabled            string `form:"enabled"`
@@ -170,6 +177,7 @@ func handleUpdateWorkspace(
 		QuotaMemoryLimit   uint64 `form:"quota-memory-limits"`
 		QuotaCPURequest    uint64 `form:"quota-cpu-requests"`
 		QuotaCPULimit      uint64 `form:"quota-cpu-limits"`
+		Cluster            string `form:"cluster"`
 		Action             string `form:"action"`
 	}
 
@@ -178,17 +186,20 @@ func handleUpdateWorkspace(
 		if err := c.Bind(&req); err != nil {
 			return err
 		}
-		id, err := model.ParseID(c.Param("id"))
+		user := c.Get("user").(*auth.User)
+
+		ctx := c.Request().Context()
+		oldWS, err := getWorkspace(c, wsSvc)
 		if err != nil {
-			return echo.ErrNotFound
+			return err
 		}
-		user := c.Get("user").(*auth.User)
+		id := oldWS.ID
 
 		if req.Action == "delete" {
-			if !user.IsAdmin() {
+			if !can(c, auth.ActionDelete, oldWS) {
 				return echo.ErrForbidden
 			}
-			err := wsSvc.DeleteWorkspace(c.Request().Context(), id)
+			err := wsSvc.DeleteWorkspace(ctx, id)
 			if err != nil {
 				return err
 			}
@@ -197,14 +208,6 @@ func handleUpdateWorkspace(
 			return c.Redirect(http.StatusSeeOther, c.Echo().Reverse("workspace-list"))
 		}
 
-		// Get current workspace state to detect enabled status change
-		ctx := c.Request().Context()
-		oldWS, err := wsSvc.GetWorkspace(ctx, id)
-		if err != nil {
-			return err
-		}
-		wasEnabled := oldWS.Enabled
-
 		upd := model.WorkspaceUpdate{
 			WorkspaceID: id,
 			ByUser:      user.Username,
@@ -239,16 +242,33 @@ func handleUpdateWorkspace(
 		var ws *model.Workspace
 		switch req.Action {
 		case "request":
-			if err := checkNodegroups(user, req.Nodegroup); err != nil {
+			if err := checkNodegroups(c, req.Nodegroup); err != nil {
 				return err
 			}
 			upd.Enabled = true // Users always want their workspace enabled
 			ws, err = wsSvc.RequestUpdateWorkspace(ctx, &upd)
 		case "update":
-			if !user.IsAdmin() {
+			if !can(c, auth.ActionReview, oldWS) {
+				return echo.ErrForbidden
+			}
+			if !can(c, auth.ActionManage, oldWS) {
+				// reviewers approve or reject the request as is
+				ws, err = wsSvc.UpdateWorkspace(ctx, reviewedUpdate(oldWS, user, upd.Enabled))
+				break
+			}
+			if !can(c, auth.ActionManage, &model.Workspace{Nodegroup: upd.Nodegroup}) {
+				// nodegroup admins can't move workspaces out of their nodegroups
 				return echo.ErrForbidden
 			}
+			if req.Cluster != "" {
+				if _, ok := clusters.Get(req.Cluster); !ok {
+					return echo.ErrBadRequest
+				}
+			}
 			ws, err = wsSvc.UpdateWorkspace(ctx, &upd)
+			if err == nil && len(clusters.Clusters) > 1 && req.Cluster != oldWS.Cluster {
+				ws, err = wsSvc.AssignCluster(ctx, id, req.Cluster)
+			}
 		default:
 			return echo.ErrBadRequest
 		}
@@ -259,19 +279,6 @@ func handleUpdateWorkspace(
 		// If not a request, we should re-render
 		if req.Action != "request" {
 			queue.Enqueue()
-
-			// Send approval/denial notification if enabled status changed
-			if !wasEnabled && ws.Enabled {
-				// Workspace was just approved (enabled)
-				if err := emailSvc.SendWorkspaceApprovalNotification(ctx, ws, true); err != nil {
-					slog.Error("failed to send workspace approval notification", "error", err)
-				}
-			} else if wasEnabled && !ws.Enabled {
-				// Workspace was just denied/disabled
-				if err := emailSvc.SendWorkspaceApprovalNotification(ctx, ws, false); err != nil {
-					slog.Error("failed to send workspace denial notification", "error", err)
-				}
-			}
 		}
 
 		// We could render HTML based on the returned ws, but that would make
@@ -281,6 +288,53 @@ func handleUpdateWorkspace(
 	}
 }
 
+// reviewedUpdate approves or rejects the pending request of ws, without any
+// other changes.
+func reviewedUpdate(ws *model.Workspace, user *auth.User, enabled bool) *model.WorkspaceUpdate {
+	req := ws.Requested()
+	return &model.WorkspaceUpdate{
+		WorkspaceID: ws.ID,
+		ByUser:      user.Username,
+		Enabled:     enabled,
+		Nodegroup:   req.Nodegroup,
+		Userdata:    req.Userdata,
+		Quotas:      req.Quotas,
+		Users:       model.Usernames(req.Users),
+	}
+}
+
+// Dry runs are synchronous, don't let them hang the request.
+const previewTimeout = time.Minute
+
+func handlePreviewWorkspace(
+	queue *worker.Queue,
+	wsSvc model.WorkspaceService,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ws, err := getWorkspace(c, wsSvc)
+		if err != nil {
+			return err
+		}
+		if !can(c, auth.ActionReview, ws) {
+			return echo.ErrForbidden
+		}
+		id := ws.ID
+		var cur *model.Workspace
+		if ws.Created {
+			cur = ws
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), previewTimeout)
+		defer cancel()
+		preview, err := queue.DryRun(ctx, cur, ws.Requested())
+		if err != nil {
+			slog.Error("failed to preview workspace", "id", id, "error", err)
+		}
+
+		return c.Render(http.StatusOK, "", view.PageWorkspacePreview(ws, preview, err))
+	}
+}
+
 func handleAcceptInvitation(
 	wsSvc model.WorkspaceService,
 ) echo.HandlerFunc {
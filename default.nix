@@ -69,11 +69,13 @@ in
 
   postInstall = ''
     cp -r deploy/chart $out/chart
-    install -m755 deploy/worker-sync.sh $out/bin/worker-sync.sh
+    install -m755 deploy/worker-kubectl.sh $out/bin/worker-kubectl.sh
+    install -m755 deploy/worker-apply.sh $out/bin/worker-apply.sh
+    install -m755 deploy/worker-harbor.sh $out/bin/worker-harbor.sh
 
     wrapProgram $out/bin/sgs \
       --prefix PATH : ${lib.makeBinPath buildInputs} \
-      --set-default SGS_WORKER_COMMAND "$out/bin/worker-sync.sh" \
+      --set-default SGS_WORKER_STAGES '[{"name":"apply","command":"'"$out"'/bin/worker-kubectl.sh","manifests":true,"dry_run":true},{"name":"harbor","command":"'"$out"'/bin/worker-harbor.sh","fields":["idHash","users"],"dry_run":true,"shared":true}]' \
       --set-default SGS_DEPLOY_CHART_PATH "$out/chart" \
       --set-default SGS_DEPLOY_REGHARBOR_PATH "$out/bin/sgs-register-harbor"
   '';
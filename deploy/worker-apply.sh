@@ -0,0 +1,6 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Renders the manifests with the Helm chart, instead of the built-in renderer.
+
+helm template sgs "$SGS_DEPLOY_CHART_PATH" -f- | "$(dirname "$0")/worker-kubectl.sh"
@@ -0,0 +1,9 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+if [[ "$SGS_DEPLOY_APPLY" != "true" ]]; then
+	echo "SGS_DEPLOY_APPLY is not true, skipping harbor registration"
+	exit 0
+fi
+
+exec "$SGS_DEPLOY_REGHARBOR_PATH"
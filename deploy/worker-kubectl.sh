@@ -0,0 +1,39 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Applies the manifests on stdin, pruning resources of deleted workspaces.
+
+export KUBECTL_APPLYSET=true
+
+# target the cluster of this run, if configured; credentials still come from
+# the kubeconfig
+kubectl=( kubectl )
+if [[ -n "${SGS_CLUSTER_SERVER:-}" ]]; then
+	kubectl+=( --server "$SGS_CLUSTER_SERVER" )
+fi
+if [[ -n "${SGS_CLUSTER_CA_DATA:-}" ]]; then
+	ca="$(mktemp)"
+	trap 'rm -f "$ca"' EXIT
+	base64 -d <<<"$SGS_CLUSTER_CA_DATA" >"$ca"
+	kubectl+=( --certificate-authority "$ca" )
+fi
+
+manifests="$(cat)"
+cmd_apply=( "${kubectl[@]}" apply --applyset workspacesets.sgs.snucse.org/sgs --prune -f- )
+
+# preview: print the rendered manifests, and their diff against the cluster
+if [[ "${SGS_WORKER_DRY_RUN:-}" == "true" ]]; then
+	echo "# rendered manifests"
+	echo "$manifests"
+	echo
+	echo "# diff against the cluster"
+	# kubectl diff exits with 1 if there are differences
+	"${kubectl[@]}" diff -f- <<<"$manifests" || [[ $? -eq 1 ]]
+	exit 0
+fi
+
+if [[ "$SGS_DEPLOY_APPLY" != "true" ]]; then
+	cmd_apply+=( --dry-run=client )
+fi
+
+"${cmd_apply[@]}" <<<"$manifests"
@@ -6,6 +6,7 @@ import (
 	"maps"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/bacchus-snu/sgs/model"
 )
@@ -62,11 +63,16 @@ type mockWorkspaces struct {
 	mu     sync.Mutex
 	nextID model.ID
 	data   map[model.ID]*model.Workspace
+	rev    int64
+
+	// workspaces awaiting an approval notification
+	announce map[model.ID]bool
 }
 
 func New() Repository {
 	return Repository{Workspaces: &mockWorkspaces{
-		data: make(map[model.ID]*model.Workspace),
+		data:     make(map[model.ID]*model.Workspace),
+		announce: make(map[model.ID]bool),
 	}}
 }
 
@@ -94,10 +100,12 @@ func (svc *mockWorkspaces) CreateWorkspace(ctx context.Context, ws *model.Worksp
 		Userdata:    newWS.Userdata,
 		Quotas:      maps.Clone(newWS.Quotas),
 		Users:       model.Usernames(newWS.Users),
+		RequestedAt: time.Now(),
 	}
 	sortUsers(newWS.Users)
 
 	svc.data[newWS.ID] = newWS
+	svc.rev++
 	return cloneWorkspace(newWS), nil
 }
 
@@ -220,6 +228,8 @@ func (svc *mockWorkspaces) UpdateWorkspace(ctx context.Context, upd *model.Works
 		}
 	}
 
+	svc.announce[ws.ID] = upd.Enabled && (svc.announce[ws.ID] || !ws.Enabled)
+
 	ws.Enabled = upd.Enabled
 	ws.Created = ws.Created || ws.Enabled // latch on
 	ws.Nodegroup = upd.Nodegroup
@@ -233,6 +243,8 @@ func (svc *mockWorkspaces) UpdateWorkspace(ctx context.Context, upd *model.Works
 	ws.Users = newUsers
 	sortUsers(ws.Users)
 	ws.Request = nil
+	ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+	svc.rev++
 
 	return cloneWorkspace(ws), nil
 }
@@ -257,7 +269,9 @@ func (svc *mockWorkspaces) RequestUpdateWorkspace(ctx context.Context, upd *mode
 	}
 
 	ws.Request = cloneWorkspaceRequest(upd)
+	ws.Request.RequestedAt = time.Now()
 	slices.Sort(ws.Request.Users)
+	svc.rev++
 	return cloneWorkspace(ws), nil
 }
 
@@ -269,9 +283,29 @@ func (svc *mockWorkspaces) DeleteWorkspace(ctx context.Context, id model.ID) err
 		return model.ErrNotFound
 	}
 	delete(svc.data, id)
+	delete(svc.announce, id)
+	svc.rev++
 	return nil
 }
 
+func (svc *mockWorkspaces) AssignCluster(ctx context.Context, id model.ID, cluster string) (*model.Workspace, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	ws, ok := svc.data[id]
+	if !ok {
+		return nil, model.ErrNotFound
+	}
+	if ws.Cluster != cluster {
+		ws.Cluster = cluster
+		if ws.Created {
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+		}
+	}
+	svc.rev++
+	return cloneWorkspace(ws), nil
+}
+
 func (svc *mockWorkspaces) ListUserInvitations(ctx context.Context, user string) ([]*model.Workspace, error) {
 	// For mock, return empty - no invitation tracking
 	return []*model.Workspace{}, nil
@@ -285,6 +319,7 @@ func (svc *mockWorkspaces) AcceptInvitation(ctx context.Context, workspaceID mod
 	if !ok || !containsUser(ws.Users, username) {
 		return model.ErrNotFound
 	}
+	svc.rev++
 	return nil
 }
 
@@ -306,5 +341,42 @@ func (svc *mockWorkspaces) DeclineInvitation(ctx context.Context, workspaceID mo
 		return model.ErrNotFound
 	}
 	ws.Users = newUsers
+	svc.rev++
 	return nil
 }
+
+func (svc *mockWorkspaces) ReportProvisioning(ctx context.Context, statuses map[model.ID]model.ProvisionStatus) ([]*model.Workspace, error) {
+	for _, status := range statuses {
+		if !status.State.Valid() {
+			return nil, model.ErrInvalid
+		}
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var ready []*model.Workspace
+	for id, status := range statuses {
+		ws, ok := svc.data[id]
+		if !ok {
+			continue
+		}
+		ws.Provision = status
+		if status.State == model.ProvisionReady && svc.announce[id] {
+			delete(svc.announce, id)
+			ready = append(ready, cloneWorkspace(ws))
+		}
+	}
+
+	slices.SortFunc(ready, func(i, j *model.Workspace) int {
+		return cmp.Compare(i.ID, j.ID)
+	})
+	return ready, nil
+}
+
+func (svc *mockWorkspaces) Revision(ctx context.Context) (int64, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.rev, nil
+}
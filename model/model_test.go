@@ -40,3 +40,70 @@ func TestHashlen(t *testing.T) {
 		t.Errorf("expected %d, got %d", want, hashLen)
 	}
 }
+
+func TestWorkspaceRequested(t *testing.T) {
+	ws := &Workspace{
+		ID:        1,
+		Created:   true,
+		Nodegroup: NodegroupUndergraduate,
+		Quotas:    map[Resource]uint64{ResGPURequest: 1},
+		Users:     []WorkspaceUser{{Username: "alice", Email: "alice@example.com"}},
+	}
+	if got := ws.Requested(); got != ws {
+		t.Errorf("Requested() = %v; want ws itself without a request", got)
+	}
+
+	ws.Request = &WorkspaceUpdate{
+		WorkspaceID: 1,
+		Enabled:     true,
+		Nodegroup:   NodegroupGraduate,
+		Quotas:      map[Resource]uint64{ResGPURequest: 2},
+		Users:       []string{"alice", "bob"},
+	}
+	got := ws.Requested()
+	if !got.Enabled || got.Nodegroup != NodegroupGraduate || got.Quotas[ResGPURequest] != 2 {
+		t.Errorf("Requested() = %+v; want request applied", got)
+	}
+	wantUsers := []WorkspaceUser{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob"},
+	}
+	if len(got.Users) != len(wantUsers) || got.Users[0] != wantUsers[0] || got.Users[1] != wantUsers[1] {
+		t.Errorf("Requested().Users = %v; want %v", got.Users, wantUsers)
+	}
+	if ws.Nodegroup != NodegroupUndergraduate {
+		t.Error("Requested() modified ws")
+	}
+}
+
+func TestSubscriberWants(t *testing.T) {
+	all := Subscriber{Mode: ModeImmediate}
+	grad := Subscriber{
+		Mode:       ModeDigest,
+		Nodegroups: []Nodegroup{NodegroupGraduate},
+		Events:     []NotificationEvent{EventWorkspaceRequested, EventWorkspaceSyncFailed},
+	}
+
+	tests := []struct {
+		sub        Subscriber
+		event      NotificationEvent
+		nodegroups []Nodegroup
+		want       bool
+	}{
+		{all, EventRequestStale, []Nodegroup{NodegroupUndergraduate}, true},
+		{grad, EventWorkspaceRequested, []Nodegroup{NodegroupGraduate}, true},
+		{grad, EventWorkspaceRequested, []Nodegroup{NodegroupUndergraduate}, false},
+		// requests moving workspaces concern the admins of either nodegroup
+		{grad, EventWorkspaceRequested, []Nodegroup{NodegroupUndergraduate, NodegroupGraduate}, true},
+		{grad, EventWorkspaceUpdateRequested, []Nodegroup{NodegroupGraduate}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.sub.Wants(tt.event, tt.nodegroups...); got != tt.want {
+			t.Errorf("%+v.Wants(%s, %v) = %v; want %v", tt.sub, tt.event, tt.nodegroups, got, tt.want)
+		}
+	}
+
+	if !grad.Valid() || (Subscriber{}).Valid() || (Subscriber{Mode: ModeDigest, Events: []NotificationEvent{EventMemberAdded}}).Valid() {
+		t.Errorf("Valid() mismatch")
+	}
+}
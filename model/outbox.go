@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// NotificationEvent is what users are notified of.
+type NotificationEvent string
+
+const (
+	// A workspace was requested, sent to subscribed admins and its users.
+	EventWorkspaceRequested NotificationEvent = "workspace_requested"
+	// Changes to a workspace were requested by one of its users.
+	EventWorkspaceUpdateRequested NotificationEvent = "workspace_update_requested"
+	// A workspace was enabled and is ready.
+	EventWorkspaceApproved NotificationEvent = "workspace_approved"
+	// An enabled workspace was disabled.
+	EventWorkspaceDenied NotificationEvent = "workspace_denied"
+	// A user was invited to a workspace, sent to the invitee.
+	EventWorkspaceInvited NotificationEvent = "workspace_invited"
+	// A user was added to a workspace, sent to the other users.
+	EventMemberAdded NotificationEvent = "member_added"
+	// A user was removed from a workspace, sent to the removed user as well.
+	EventMemberRemoved NotificationEvent = "member_removed"
+	// Provisioning a workspace failed, after it had not.
+	EventWorkspaceSyncFailed NotificationEvent = "workspace_sync_failed"
+	// A request has been pending for long, sent to subscribed admins.
+	EventRequestStale NotificationEvent = "workspace_request_stale"
+)
+
+// UserEvents are the events users are notified of about their workspaces,
+// subject to their NotificationPreferences.
+var UserEvents = []NotificationEvent{
+	EventWorkspaceRequested,
+	EventWorkspaceUpdateRequested,
+	EventWorkspaceApproved,
+	EventWorkspaceDenied,
+	EventWorkspaceInvited,
+	EventMemberAdded,
+	EventMemberRemoved,
+	EventWorkspaceSyncFailed,
+}
+
+// Notification is an entry of the outbox. It is written in the same
+// transaction as the change it notifies of, and delivered in the background,
+// so that notifications are neither lost nor block the change.
+type Notification struct {
+	ID    ID
+	Event NotificationEvent
+	// The workspace as of the event.
+	Workspace *Workspace
+	// The user invited, added, or removed, or who filed the request.
+	Username  string
+	CreatedAt time.Time
+
+	// Names of the channels that delivered it, skipped by retries.
+	Delivered []string
+	// Failed delivery attempts so far.
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	// Zero until delivered.
+	SentAt time.Time
+	// Set when delivery was given up on, until retried by an admin.
+	Dead bool
+}
+
+type OutboxService interface {
+	// Claim up to limit undelivered notifications due at now, oldest first,
+	// and postpone them by lease, so that other senders skip them meanwhile.
+	ClaimNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Notification, error)
+	// Record that the named channel delivered the notification, so that
+	// retries only deliver it through the others.
+	MarkDelivered(ctx context.Context, id ID, channel string) error
+	// Record that the notification was delivered through all channels at t.
+	MarkSent(ctx context.Context, id ID, t time.Time) error
+	// Record a failed delivery attempt, to be retried at next. A zero next
+	// gives up on the notification.
+	MarkFailed(ctx context.Context, id ID, reason string, next time.Time) error
+
+	// List undelivered notifications, and the limit most recently created
+	// delivered ones, most recent first.
+	ListNotifications(ctx context.Context, limit int) ([]*Notification, error)
+	// Retry delivering an undelivered notification right away, from the first
+	// attempt. Returns ErrNotFound if it was delivered or does not exist.
+	RetryNotification(ctx context.Context, id ID) error
+	// Delete notifications delivered before t.
+	DeleteSentNotifications(ctx context.Context, before time.Time) error
+
+	// Enqueue an EventRequestStale reminder of every request pending for
+	// longer than age at now, unless one was enqueued within age. Returns the
+	// number of reminders enqueued.
+	EnqueueReminders(ctx context.Context, now time.Time, age time.Duration) (int, error)
+	// Claim the run of the named job due at due, unless it was claimed at or
+	// after due already. Concurrent callers claim it only once.
+	ClaimJob(ctx context.Context, name string, due time.Time) (bool, error)
+}
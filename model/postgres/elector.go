@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// electorInterval is how often followers try to take the lock, and how often
+// the leader checks that its connection is alive.
+const electorInterval = 5 * time.Second
+
+// Elector elects a leader among replicas with a session-level advisory lock,
+// held on a connection dedicated to the election.
+type Elector struct {
+	pool *pgxpool.Pool
+	name string
+}
+
+// Elector returns an Elector for the lock with the given name.
+func (r *Repository) Elector(name string) *Elector {
+	return &Elector{r.pool, name}
+}
+
+func (e *Elector) Lead(ctx context.Context, fn func(ctx context.Context) error) error {
+	pconn, err := e.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The lock belongs to the session, never return it to the pool. Closing
+	// the connection releases the lock, handing over leadership.
+	conn := pconn.Hijack()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), electorInterval)
+		defer cancel()
+		conn.Close(ctx)
+	}()
+
+	tick := time.NewTicker(electorInterval)
+	defer tick.Stop()
+	for {
+		var locked bool
+		err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, e.name).
+			Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("taking lock: %w", err)
+		}
+		if locked {
+			break
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	lctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// the lock is lost along with the connection
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-tick.C:
+				if err := conn.Ping(lctx); err != nil {
+					cancel(fmt.Errorf("lost lock: %w", err))
+					return
+				}
+			case <-lctx.Done():
+				return
+			}
+		}
+	}()
+
+	err = fn(lctx)
+	cancel(nil)
+	<-done
+
+	if cause := context.Cause(lctx); ctx.Err() == nil && !errors.Is(cause, context.Canceled) {
+		err = errors.Join(err, cause)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// workspacesChannel is notified whenever workspaces are modified.
+const workspacesChannel = "sgs_workspaces"
+
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = time.Minute
+)
+
+// ListenWorkspaces calls fn whenever workspaces are modified by any replica,
+// until ctx is done. Notifications of a single transaction are coalesced by
+// Postgres. It listens on a dedicated connection, reconnecting with backoff.
+func (r *Repository) ListenWorkspaces(ctx context.Context, fn func()) error {
+	delay := minListenBackoff
+	for {
+		connected, err := r.listen(ctx, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			delay = minListenBackoff
+		}
+		log.Printf("postgres: listening for changes: %v; reconnecting in %v", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(2*delay, maxListenBackoff)
+	}
+}
+
+// listen reports whether it started listening before failing.
+func (r *Repository) listen(ctx context.Context, fn func()) (bool, error) {
+	conn, err := pgx.Connect(ctx, *r.connString.Load())
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn.Close(ctx)
+	}()
+
+	if _, err := conn.Exec(ctx, `LISTEN `+workspacesChannel); err != nil {
+		return false, err
+	}
+	// changes may have been missed while disconnected
+	fn()
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return true, err
+		}
+		fn()
+	}
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"errors"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,12 +14,49 @@ type mailingListRepository struct {
 	pool *pgxpool.Pool
 }
 
-func (r *mailingListRepository) Subscribe(ctx context.Context, username, email string) error {
+// toStrings converts the filters of subscribers to and from TEXT[].
+func toStrings[T ~string](vs []T) []string {
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = string(v)
+	}
+	return ss
+}
+
+func fromStrings[T ~string](ss []string) []T {
+	if len(ss) == 0 {
+		return nil
+	}
+	vs := make([]T, len(ss))
+	for i, s := range ss {
+		vs[i] = T(s)
+	}
+	return vs
+}
+
+const subscriberColumns = `username, email, mode, nodegroups, events`
+
+func scanSubscriber(row pgx.CollectableRow) (model.Subscriber, error) {
+	var (
+		sub                model.Subscriber
+		nodegroups, events []string
+	)
+	err := row.Scan(&sub.Username, &sub.Email, &sub.Mode, &nodegroups, &events)
+	sub.Nodegroups = fromStrings[model.Nodegroup](nodegroups)
+	sub.Events = fromStrings[model.NotificationEvent](events)
+	return sub, err
+}
+
+func (r *mailingListRepository) Subscribe(ctx context.Context, sub *model.Subscriber) error {
+	if !sub.Valid() {
+		return model.ErrInvalid
+	}
 	_, err := r.pool.Exec(ctx, `
-		INSERT INTO mailing_list (username, email)
-		VALUES ($1, $2)
-		ON CONFLICT (username) DO UPDATE SET email = $2
-	`, username, email)
+		INSERT INTO mailing_list (username, email, mode, nodegroups, events)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username) DO UPDATE
+		SET email = $2, mode = $3, nodegroups = $4, events = $5
+	`, sub.Username, sub.Email, sub.Mode, toStrings(sub.Nodegroups), toStrings(sub.Events))
 	return err
 }
 
@@ -29,27 +67,33 @@ func (r *mailingListRepository) Unsubscribe(ctx context.Context, username string
 	return err
 }
 
-func (r *mailingListRepository) IsSubscribed(ctx context.Context, username string) (bool, error) {
-	var exists bool
-	err := r.pool.QueryRow(ctx, `
-		SELECT EXISTS(SELECT 1 FROM mailing_list WHERE username = $1)
-	`, username).Scan(&exists)
+func (r *mailingListRepository) GetSubscription(ctx context.Context, username string) (*model.Subscriber, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+subscriberColumns+` FROM mailing_list WHERE username = $1
+	`, username)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	sub, err := pgx.CollectExactlyOneRow(rows, scanSubscriber)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
-	return exists, nil
+	return &sub, nil
 }
 
 func (r *mailingListRepository) ListSubscribers(ctx context.Context) ([]model.Subscriber, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT username, email FROM mailing_list ORDER BY username
+		SELECT `+subscriberColumns+` FROM mailing_list ORDER BY username
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	subscribers, err := pgx.CollectRows(rows, pgx.RowToStructByName[model.Subscriber])
+	subscribers, err := pgx.CollectRows(rows, scanSubscriber)
 	if err != nil {
 		return nil, err
 	}
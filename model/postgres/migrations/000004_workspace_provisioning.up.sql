@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS workspaces_provisioning (
+	workspace_id BIGINT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+	state TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	-- users are notified once the workspace becomes ready after being enabled
+	announce BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (workspace_id)
+);
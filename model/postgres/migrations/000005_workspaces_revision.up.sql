@@ -0,0 +1,7 @@
+-- single row, bumped by every change to workspaces
+CREATE TABLE IF NOT EXISTS workspaces_revision (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	value BIGINT NOT NULL
+);
+
+INSERT INTO workspaces_revision (value) VALUES (0) ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- empty places the workspace in the default cluster of its nodegroup
+ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS cluster TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    -- SHA-256 of the token in the session cookie
+    token_hash BYTEA NOT NULL UNIQUE,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    groups TEXT[] NOT NULL,
+    refresh_token TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    remote_ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    validated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_username ON sessions (username);
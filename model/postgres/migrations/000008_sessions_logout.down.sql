@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS sessions_subject;
+ALTER TABLE sessions DROP COLUMN IF EXISTS id_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS provider_session_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS subject;
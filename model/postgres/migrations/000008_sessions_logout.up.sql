@@ -0,0 +1,6 @@
+-- matched by back-channel logouts, and passed to RP-initiated logouts
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS provider_session_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS id_token TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS sessions_subject ON sessions (subject);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS impersonation_log;
+ALTER TABLE sessions DROP COLUMN IF EXISTS impersonating;
@@ -0,0 +1,11 @@
+-- the impersonated user, NULL unless an admin impersonates someone
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonating JSONB;
+
+-- audit log, kept after sessions are gone
+CREATE TABLE IF NOT EXISTS impersonation_log (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    admin TEXT NOT NULL,
+    username TEXT NOT NULL,
+    event TEXT NOT NULL CHECK (event IN ('start', 'stop'))
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_settings (
+    username TEXT PRIMARY KEY,
+    -- preferred language of emails
+    language TEXT NOT NULL DEFAULT ''
+);
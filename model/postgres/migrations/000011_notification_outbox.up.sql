@@ -0,0 +1,18 @@
+-- notifications written together with the changes causing them, and
+-- delivered in the background
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event TEXT NOT NULL,
+    -- snapshot of the workspace as of the event
+    workspace JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMPTZ,
+    dead BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS notification_outbox_due
+    ON notification_outbox (next_attempt_at) WHERE sent_at IS NULL AND NOT dead;
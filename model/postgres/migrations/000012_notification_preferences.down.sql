@@ -0,0 +1,4 @@
+ALTER TABLE notification_outbox DROP COLUMN IF EXISTS username;
+DROP TABLE IF EXISTS notification_preferences;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS notify_email;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS email;
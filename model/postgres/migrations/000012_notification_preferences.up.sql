@@ -0,0 +1,16 @@
+-- the address the user last logged in with, for users without one in a
+-- workspace, and an address they chose to be notified at instead
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS notify_email TEXT NOT NULL DEFAULT '';
+
+-- opt-ins per event and channel, users are notified of missing ones
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    username TEXT NOT NULL,
+    event TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (username, event, channel)
+);
+
+-- the user invited, added, or removed
+ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS username TEXT NOT NULL DEFAULT '';
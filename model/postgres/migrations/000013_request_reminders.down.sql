@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS scheduled_jobs;
+ALTER TABLE workspaces_updaterequests DROP COLUMN IF EXISTS reminded_at;
+ALTER TABLE workspaces_updaterequests DROP COLUMN IF EXISTS requested_at;
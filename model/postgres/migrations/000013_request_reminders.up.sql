@@ -0,0 +1,10 @@
+-- pending requests are escalated to admins once they are old
+ALTER TABLE workspaces_updaterequests ADD COLUMN IF NOT EXISTS requested_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE workspaces_updaterequests ADD COLUMN IF NOT EXISTS reminded_at TIMESTAMPTZ;
+
+-- last runs of jobs scheduled on any one replica, eg. the daily digest
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name TEXT NOT NULL,
+    last_run_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (name)
+);
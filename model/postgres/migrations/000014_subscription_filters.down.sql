@@ -0,0 +1,3 @@
+ALTER TABLE mailing_list DROP COLUMN IF EXISTS events;
+ALTER TABLE mailing_list DROP COLUMN IF EXISTS nodegroups;
+ALTER TABLE mailing_list DROP COLUMN IF EXISTS mode;
@@ -0,0 +1,5 @@
+-- subscriptions filtered by nodegroup and event, empty for all of them, and
+-- emailed immediately or as a daily digest
+ALTER TABLE mailing_list ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT 'immediate';
+ALTER TABLE mailing_list ADD COLUMN IF NOT EXISTS nodegroups TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE mailing_list ADD COLUMN IF NOT EXISTS events TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- channels that delivered a notification, skipped when retrying the others
+ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS delivered TEXT[] NOT NULL DEFAULT '{}';
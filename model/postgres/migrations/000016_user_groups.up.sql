@@ -0,0 +1,3 @@
+-- the groups the user last logged in with, for the policy to be checked when
+-- emailing subscribed admins
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS groups TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+ALTER TABLE user_settings DROP COLUMN IF EXISTS notify_email_expires_at;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS notify_email_token;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS notify_email_pending;
@@ -0,0 +1,5 @@
+-- an address the user asked to be emailed at instead, used once they follow
+-- the link sent to it, with the hash of its token
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS notify_email_pending TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS notify_email_token BYTEA;
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS notify_email_expires_at TIMESTAMPTZ;
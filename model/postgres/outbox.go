@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+type outboxRepository struct {
+	pool *pgxpool.Pool
+}
+
+// enqueueNotification writes a notification of ws to the outbox, in the
+// transaction of the change causing it. username is the user the event is
+// about, if any.
+func enqueueNotification(ctx context.Context, tx pgx.Tx, event model.NotificationEvent, ws *model.Workspace, username string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO notification_outbox (event, workspace, username) VALUES ($1, $2, $3)`,
+		event, ws, username)
+	return err
+}
+
+const notificationColumns = `id, event, workspace, username, created_at, delivered, attempts, next_attempt_at, last_error, sent_at, dead`
+
+func scanNotification(row pgx.CollectableRow) (*model.Notification, error) {
+	var n model.Notification
+	var sentAt *time.Time
+	err := row.Scan(
+		&n.ID, &n.Event, &n.Workspace, &n.Username, &n.CreatedAt, &n.Delivered,
+		&n.Attempts, &n.NextAttemptAt, &n.LastError, &sentAt, &n.Dead,
+	)
+	if sentAt != nil {
+		n.SentAt = *sentAt
+	}
+	return &n, err
+}
+
+func (r *outboxRepository) ClaimNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.Notification, error) {
+	// skip rows claimed concurrently by other replicas
+	rows, err := r.pool.Query(ctx, `
+		UPDATE notification_outbox SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE sent_at IS NULL AND NOT dead AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+notificationColumns,
+		now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := pgx.CollectRows(rows, scanNotification)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(ns, func(a, b *model.Notification) int { return cmp.Compare(a.ID, b.ID) })
+	return ns, nil
+}
+
+func (r *outboxRepository) MarkDelivered(ctx context.Context, id model.ID, channel string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE notification_outbox SET delivered = array_append(array_remove(delivered, $2), $2)
+		WHERE id = $1`,
+		id, channel)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *outboxRepository) MarkSent(ctx context.Context, id model.ID, t time.Time) error {
+	tag, err := r.pool.Exec(ctx, `UPDATE notification_outbox SET sent_at = $2 WHERE id = $1`, id, t)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *outboxRepository) MarkFailed(ctx context.Context, id model.ID, reason string, next time.Time) error {
+	var nextAt *time.Time
+	if !next.IsZero() {
+		nextAt = &next
+	}
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE notification_outbox
+		SET attempts = attempts + 1, last_error = $2,
+			next_attempt_at = COALESCE($3, next_attempt_at), dead = $3 IS NULL
+		WHERE id = $1`,
+		id, reason, nextAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *outboxRepository) ListNotifications(ctx context.Context, limit int) ([]*model.Notification, error) {
+	rows, err := r.pool.Query(ctx, `
+		(SELECT `+notificationColumns+` FROM notification_outbox WHERE sent_at IS NULL)
+		UNION ALL
+		(SELECT `+notificationColumns+` FROM notification_outbox WHERE sent_at IS NOT NULL
+			ORDER BY created_at DESC, id DESC LIMIT $1)
+		ORDER BY created_at DESC, id DESC`,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanNotification)
+}
+
+func (r *outboxRepository) RetryNotification(ctx context.Context, id model.ID) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE notification_outbox
+		SET attempts = 0, last_error = '', next_attempt_at = now(), dead = FALSE
+		WHERE id = $1 AND sent_at IS NULL`,
+		id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *outboxRepository) DeleteSentNotifications(ctx context.Context, before time.Time) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM notification_outbox WHERE sent_at < $1`, before)
+	return err
+}
+
+func (r *outboxRepository) EnqueueReminders(ctx context.Context, now time.Time, age time.Duration) (int, error) {
+	var count int
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		// rows locked by concurrent callers are re-checked once they commit
+		rows, err := tx.Query(ctx, `
+			UPDATE workspaces_updaterequests SET reminded_at = $1
+			WHERE requested_at <= $2 AND (reminded_at IS NULL OR reminded_at <= $2)
+			RETURNING workspace_id`,
+			now, now.Add(-age))
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[model.ID])
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		wss, err := queryWorkspaces(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+		for _, ws := range wss {
+			if err := enqueueNotification(ctx, tx, model.EventRequestStale, ws, ws.Request.ByUser); err != nil {
+				return err
+			}
+		}
+		count = len(wss)
+		return nil
+	})
+	return count, err
+}
+
+func (r *outboxRepository) ClaimJob(ctx context.Context, name string, due time.Time) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		INSERT INTO scheduled_jobs (name, last_run_at) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET last_run_at = EXCLUDED.last_run_at
+		WHERE scheduled_jobs.last_run_at < EXCLUDED.last_run_at`,
+		name, due)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
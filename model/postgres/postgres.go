@@ -5,7 +5,10 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
 	"slices"
+	"sync/atomic"
 
 	"github.com/golang-migrate/migrate/v4"
 	migratepgx "github.com/golang-migrate/migrate/v4/database/pgx/v5"
@@ -17,6 +20,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/secret"
 )
 
 //go:embed migrations/*.sql
@@ -42,22 +46,43 @@ func openMigrations(connString string) (*migrate.Migrate, error) {
 }
 
 type Config struct {
-	ConnString string `mapstructure:"conn_string"`
+	ConnString     string `mapstructure:"conn_string"`
+	ConnStringFile string `mapstructure:"conn_string_file"`
 }
 
 func (c *Config) Bind() {
 	viper.BindEnv("postgres.conn_string", "SGS_POSTGRES_CONN_STRING")
+	viper.BindEnv("postgres.conn_string_file", "SGS_POSTGRES_CONN_STRING_FILE")
 }
 
 func (c *Config) Validate() error {
+	if err := secret.Load(&c.ConnString, c.ConnStringFile); err != nil {
+		return fmt.Errorf("conn_string_file: %w", err)
+	}
 	if c.ConnString == "" {
 		return errors.New("conn_string is required")
 	}
 	return nil
 }
 
+// Redacted returns a copy of c with the password in the connection string
+// redacted.
+func (c Config) Redacted() Config {
+	if u, err := url.Parse(c.ConnString); err == nil && u.User != nil {
+		c.ConnString = u.Redacted()
+	} else {
+		c.ConnString = dsnPassword.ReplaceAllString(c.ConnString, "${1}xxxxx")
+	}
+	return c
+}
+
+// password of key=value connection strings
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
 type Repository struct {
 	pool *pgxpool.Pool
+	// replaced when credentials are rotated
+	connString atomic.Pointer[string]
 }
 
 func New(ctx context.Context, cfg Config) (*Repository, error) {
@@ -71,18 +96,45 @@ func New(ctx context.Context, cfg Config) (*Repository, error) {
 		return nil, fmt.Errorf("applying migrations: %w", err)
 	}
 
-	pool, err := pgxpool.New(ctx, cfg.ConnString)
+	r := &Repository{}
+	r.connString.Store(&cfg.ConnString)
+
+	poolCfg, err := pgxpool.ParseConfig(cfg.ConnString)
+	if err != nil {
+		return nil, fmt.Errorf("parsing conn_string: %w", err)
+	}
+	// new connections use the current credentials
+	poolCfg.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		cur, err := pgx.ParseConfig(*r.connString.Load())
+		if err != nil {
+			return err
+		}
+		cc.User, cc.Password = cur.User, cur.Password
+		return nil
+	}
+
+	r.pool, err = pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("opening pool: %w", err)
 	}
 
 	// check if the pool is working
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
+	if err := r.pool.Ping(ctx); err != nil {
+		r.pool.Close()
 		return nil, fmt.Errorf("pinging pool: %w", err)
 	}
 
-	return &Repository{pool}, nil
+	return r, nil
+}
+
+// SetConnString rotates the credentials of new connections. Other changes to
+// the connection string require a restart.
+func (r *Repository) SetConnString(connString string) error {
+	if _, err := pgx.ParseConfig(connString); err != nil {
+		return err
+	}
+	r.connString.Store(&connString)
+	return nil
 }
 
 func (r *Repository) Close() error {
@@ -98,6 +150,18 @@ func (r *Repository) MailingList() *mailingListRepository {
 	return &mailingListRepository{r.pool}
 }
 
+func (r *Repository) Sessions() *sessionsRepository {
+	return &sessionsRepository{r.pool}
+}
+
+func (r *Repository) Users() *usersRepository {
+	return &usersRepository{r.pool}
+}
+
+func (r *Repository) Outbox() *outboxRepository {
+	return &outboxRepository{r.pool}
+}
+
 type workspacesRepository struct {
 	pool *pgxpool.Pool
 }
@@ -146,12 +210,21 @@ func (svc *workspacesRepository) CreateWorkspace(ctx context.Context, ws *model.
 			INSERT INTO workspaces_updaterequests  (workspace_id, by_user, data)
 			VALUES ($1, $2, $3)
 			ON CONFLICT (workspace_id) DO UPDATE
-			SET by_user = EXCLUDED.by_user, data = EXCLUDED.data`,
+			SET by_user = EXCLUDED.by_user, data = EXCLUDED.data,
+				requested_at = CURRENT_TIMESTAMP, reminded_at = NULL`,
 			upd.WorkspaceID, upd.ByUser, upd)
 
+		if err := bumpRevision(ctx, tx); err != nil {
+			return err
+		}
+
 		// we could reconstruct the ws here, but it's easier to just query it
 		newWs, err = queryWorkspace(ctx, tx, id)
-		return err
+		if err != nil {
+			return err
+		}
+
+		return enqueueNotification(ctx, tx, model.EventWorkspaceRequested, newWs, ws.Users[0].Username)
 	})
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -305,13 +378,34 @@ func (svc *workspacesRepository) UpdateWorkspace(ctx context.Context, upd *model
 
 	var ws *model.Workspace
 	err := pgx.BeginFunc(ctx, svc.pool, func(tx pgx.Tx) error {
-		tag, err := tx.Exec(ctx, `UPDATE workspaces SET created = created OR $2, enabled = $2, nodegroup = $3, userdata = $4 WHERE id = $1`,
+		rows, err := tx.Query(ctx, `SELECT enabled FROM workspaces WHERE id = $1 FOR UPDATE`,
+			upd.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		wasEnabled, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[bool])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE workspaces SET created = created OR $2, enabled = $2, nodegroup = $3, userdata = $4 WHERE id = $1`,
 			upd.WorkspaceID, upd.Enabled, upd.Nodegroup, upd.Userdata)
 		if err != nil {
 			return err
 		}
-		if tag.RowsAffected() == 0 {
-			return model.ErrNotFound
+
+		// changes must be provisioned again; newly enabled workspaces are
+		// announced to users once ready
+		_, err = tx.Exec(ctx, `
+			INSERT INTO workspaces_provisioning (workspace_id, state, announce)
+			VALUES ($1, $2, $3 AND $4)
+			ON CONFLICT (workspace_id) DO UPDATE
+			SET state = EXCLUDED.state, message = '',
+				announce = $3 AND (workspaces_provisioning.announce OR $4),
+				updated_at = CURRENT_TIMESTAMP`,
+			upd.WorkspaceID, model.ProvisionPending, upd.Enabled, !wasEnabled)
+		if err != nil {
+			return err
 		}
 
 		quotas := make([]model.Resource, 0, len(upd.Quotas))
@@ -336,6 +430,16 @@ func (svc *workspacesRepository) UpdateWorkspace(ctx context.Context, upd *model
 			}
 		}
 
+		rows, err = tx.Query(ctx, `SELECT username FROM workspaces_users WHERE workspace_id = $1`,
+			upd.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		oldUsers, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+
 		_, err = tx.Exec(ctx, `DELETE FROM workspaces_users WHERE workspace_id = $1 AND username != ALL($2)`,
 			upd.WorkspaceID, upd.Users)
 		if err != nil {
@@ -358,8 +462,42 @@ func (svc *workspacesRepository) UpdateWorkspace(ctx context.Context, upd *model
 			return err
 		}
 
+		if err := bumpRevision(ctx, tx); err != nil {
+			return err
+		}
+
 		ws, err = queryWorkspace(ctx, tx, upd.WorkspaceID)
-		return err
+		if err != nil {
+			return err
+		}
+
+		// approvals are notified once the workspace is ready, denials right
+		// away
+		if wasEnabled && !upd.Enabled {
+			if err := enqueueNotification(ctx, tx, model.EventWorkspaceDenied, ws, ""); err != nil {
+				return err
+			}
+		}
+		for _, user := range upd.Users {
+			if slices.Contains(oldUsers, user) {
+				continue
+			}
+			if err := enqueueNotification(ctx, tx, model.EventWorkspaceInvited, ws, user); err != nil {
+				return err
+			}
+			if err := enqueueNotification(ctx, tx, model.EventMemberAdded, ws, user); err != nil {
+				return err
+			}
+		}
+		for _, user := range oldUsers {
+			if slices.Contains(upd.Users, user) {
+				continue
+			}
+			if err := enqueueNotification(ctx, tx, model.EventMemberRemoved, ws, user); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, model.ErrNotFound
@@ -390,7 +528,8 @@ func (svc *workspacesRepository) RequestUpdateWorkspace(ctx context.Context, upd
 			INSERT INTO workspaces_updaterequests  (workspace_id, by_user, data)
 			VALUES ($1, $2, $3)
 			ON CONFLICT (workspace_id) DO UPDATE
-			SET by_user = EXCLUDED.by_user, data = EXCLUDED.data`,
+			SET by_user = EXCLUDED.by_user, data = EXCLUDED.data,
+				requested_at = CURRENT_TIMESTAMP, reminded_at = NULL`,
 			upd.WorkspaceID, upd.ByUser, upd)
 		if err != nil {
 			if pgerr := (*pgconn.PgError)(nil); errors.As(err, &pgerr) && pgerr.Code == pgerrcode.ForeignKeyViolation {
@@ -399,8 +538,16 @@ func (svc *workspacesRepository) RequestUpdateWorkspace(ctx context.Context, upd
 			return err
 		}
 
+		if err := bumpRevision(ctx, tx); err != nil {
+			return err
+		}
+
 		ws, err = queryWorkspace(ctx, tx, upd.WorkspaceID)
-		return err
+		if err != nil {
+			return err
+		}
+
+		return enqueueNotification(ctx, tx, model.EventWorkspaceUpdateRequested, ws, upd.ByUser)
 	})
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, model.ErrNotFound
@@ -417,7 +564,7 @@ func (svc *workspacesRepository) DeleteWorkspace(ctx context.Context, id model.I
 		if tag.RowsAffected() == 0 {
 			return model.ErrNotFound
 		}
-		return nil
+		return bumpRevision(ctx, tx)
 	})
 	if errors.Is(err, pgx.ErrNoRows) {
 		return model.ErrNotFound
@@ -425,6 +572,49 @@ func (svc *workspacesRepository) DeleteWorkspace(ctx context.Context, id model.I
 	return err
 }
 
+func (svc *workspacesRepository) AssignCluster(ctx context.Context, id model.ID, cluster string) (*model.Workspace, error) {
+	var ws *model.Workspace
+	err := pgx.BeginFunc(ctx, svc.pool, func(tx pgx.Tx) error {
+		var (
+			oldCluster string
+			created    bool
+		)
+		err := tx.QueryRow(ctx, `SELECT cluster, created FROM workspaces WHERE id = $1 FOR UPDATE`, id).
+			Scan(&oldCluster, &created)
+		if err != nil {
+			return err
+		}
+
+		if oldCluster != cluster {
+			_, err = tx.Exec(ctx, `UPDATE workspaces SET cluster = $2 WHERE id = $1`, id, cluster)
+			if err != nil {
+				return err
+			}
+			if created {
+				_, err = tx.Exec(ctx, `
+					UPDATE workspaces_provisioning
+					SET state = 'pending', message = '', updated_at = CURRENT_TIMESTAMP
+					WHERE workspace_id = $1`,
+					id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := bumpRevision(ctx, tx); err != nil {
+			return err
+		}
+
+		ws, err = queryWorkspace(ctx, tx, id)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrNotFound
+	}
+	return ws, err
+}
+
 func (svc *workspacesRepository) ListUserInvitations(ctx context.Context, user string) ([]*model.Workspace, error) {
 	var wss []*model.Workspace
 	err := pgx.BeginFunc(ctx, svc.pool, func(tx pgx.Tx) error {
@@ -471,7 +661,7 @@ func (svc *workspacesRepository) AcceptInvitation(ctx context.Context, workspace
 		if tag.RowsAffected() == 0 {
 			return model.ErrNotFound
 		}
-		return nil
+		return bumpRevision(ctx, tx)
 	})
 	if errors.Is(err, pgx.ErrNoRows) {
 		return model.ErrNotFound
@@ -490,10 +680,105 @@ func (svc *workspacesRepository) DeclineInvitation(ctx context.Context, workspac
 		if tag.RowsAffected() == 0 {
 			return model.ErrNotFound
 		}
-		return nil
+		return bumpRevision(ctx, tx)
 	})
 	if errors.Is(err, pgx.ErrNoRows) {
 		return model.ErrNotFound
 	}
 	return err
 }
+
+func (svc *workspacesRepository) ReportProvisioning(ctx context.Context, statuses map[model.ID]model.ProvisionStatus) ([]*model.Workspace, error) {
+	for _, status := range statuses {
+		if !status.State.Valid() {
+			return nil, model.ErrInvalid
+		}
+	}
+
+	var wss []*model.Workspace
+	err := pgx.BeginFunc(ctx, svc.pool, func(tx pgx.Tx) error {
+		var ready, failed []model.ID
+		for id, status := range statuses {
+			// no row for workspaces never reported on
+			var announce bool
+			var prevState model.ProvisionState
+			err := tx.QueryRow(ctx, `SELECT announce, state FROM workspaces_provisioning WHERE workspace_id = $1 FOR UPDATE`,
+				id).Scan(&announce, &prevState)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			// workspaces may have been deleted since they were listed, only
+			// insert for existing ones
+			tag, err := tx.Exec(ctx, `
+				INSERT INTO workspaces_provisioning AS p (workspace_id, state, message)
+				SELECT id, $2, $3 FROM workspaces WHERE id = $1
+				ON CONFLICT (workspace_id) DO UPDATE
+				SET state = EXCLUDED.state, message = EXCLUDED.message,
+					announce = p.announce AND EXCLUDED.state != $4,
+					updated_at = CURRENT_TIMESTAMP`,
+				id, status.State, status.Message, model.ProvisionReady)
+			if err != nil {
+				return err
+			}
+
+			if announce && status.State == model.ProvisionReady {
+				ready = append(ready, id)
+			}
+			// only the first of consecutive failures is notified
+			if tag.RowsAffected() > 0 && status.State == model.ProvisionFailed && prevState != model.ProvisionFailed {
+				failed = append(failed, id)
+			}
+		}
+
+		slices.Sort(ready)
+		var err error
+		wss, err = queryWorkspaces(ctx, tx, ready)
+		if err != nil {
+			return err
+		}
+		for _, ws := range wss {
+			if err := enqueueNotification(ctx, tx, model.EventWorkspaceApproved, ws, ""); err != nil {
+				return err
+			}
+		}
+
+		slices.Sort(failed)
+		failedWss, err := queryWorkspaces(ctx, tx, failed)
+		if err != nil {
+			return err
+		}
+		for _, ws := range failedWss {
+			if err := enqueueNotification(ctx, tx, model.EventWorkspaceSyncFailed, ws, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wss, nil
+}
+
+func (svc *workspacesRepository) Revision(ctx context.Context) (int64, error) {
+	var rev int64
+	err := svc.pool.QueryRow(ctx, `SELECT value FROM workspaces_revision`).Scan(&rev)
+	if err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// bumpRevision must be called by every transaction modifying workspaces. The
+// new revision becomes visible together with the changes, and listeners are
+// notified on commit.
+func bumpRevision(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `UPDATE workspaces_revision SET value = value + 1`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, '')`, workspacesChannel)
+	return err
+}
@@ -0,0 +1,205 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+type sessionsRepository struct {
+	pool *pgxpool.Pool
+}
+
+// Tokens are only stored hashed, so they can't be recovered from the db.
+//
+// Refresh and ID tokens are stored as is, as they are presented to the
+// provider. Encrypting them with a key of sgs would not protect them from
+// anyone reading both the db and the configuration, and either alone is of
+// little use: refreshing also requires the secret of the sgs client, which is
+// not in the db, and ID tokens are only hints of who logs out.
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+const sessionColumns = `id, subject, username, email, groups, refresh_token, id_token, provider_session_id, impersonating, user_agent, remote_ip, created_at, last_seen_at, validated_at`
+
+func scanSession(row pgx.CollectableRow) (*model.Session, error) {
+	var sess model.Session
+	err := row.Scan(
+		&sess.ID, &sess.Subject, &sess.Username, &sess.Email, &sess.Groups,
+		&sess.RefreshToken, &sess.IDToken, &sess.ProviderSessionID, &sess.Impersonating, &sess.UserAgent, &sess.RemoteIP, &sess.CreatedAt, &sess.LastSeenAt, &sess.ValidatedAt,
+	)
+	return &sess, err
+}
+
+func (r *sessionsRepository) CreateSession(ctx context.Context, sess *model.Session, token string) (*model.Session, error) {
+	if sess.Groups == nil {
+		sess.Groups = []string{}
+	}
+	rows, err := r.pool.Query(ctx, `
+		INSERT INTO sessions (token_hash, subject, username, email, groups, refresh_token, id_token, provider_session_id, user_agent, remote_ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+sessionColumns,
+		hashToken(token), sess.Subject, sess.Username, sess.Email, sess.Groups,
+		sess.RefreshToken, sess.IDToken, sess.ProviderSessionID, sess.UserAgent, sess.RemoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, scanSession)
+}
+
+func (r *sessionsRepository) GetSession(ctx context.Context, token string) (*model.Session, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE token_hash = $1`, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	sess, err := pgx.CollectExactlyOneRow(rows, scanSession)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, model.ErrNotFound
+	}
+	return sess, err
+}
+
+func (r *sessionsRepository) ListUserSessions(ctx context.Context, username string) ([]*model.Session, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE username = $1 ORDER BY last_seen_at DESC, id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanSession)
+}
+
+func (r *sessionsRepository) ListAllSessions(ctx context.Context) ([]*model.Session, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sessionColumns+` FROM sessions ORDER BY last_seen_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanSession)
+}
+
+func (r *sessionsRepository) TouchSession(ctx context.Context, id model.ID, t time.Time) error {
+	_, err := r.pool.Exec(ctx, `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, id, t)
+	return err
+}
+
+func (r *sessionsRepository) ClaimRefresh(ctx context.Context, id model.ID, validatedAt, t time.Time) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `UPDATE sessions SET validated_at = $3 WHERE id = $1 AND validated_at = $2`,
+		id, validatedAt, t)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *sessionsRepository) ValidateSession(ctx context.Context, sess *model.Session, t time.Time) error {
+	groups := sess.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE sessions
+		SET subject = $2, username = $3, email = $4, groups = $5,
+			refresh_token = $6, id_token = $7, provider_session_id = $8, validated_at = $9
+		WHERE id = $1`,
+		sess.ID, sess.Subject, sess.Username, sess.Email, groups,
+		sess.RefreshToken, sess.IDToken, sess.ProviderSessionID, t)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *sessionsRepository) StartImpersonation(ctx context.Context, id model.ID, imp *model.Impersonation) error {
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var admin string
+		err := tx.QueryRow(ctx, `UPDATE sessions SET impersonating = $2 WHERE id = $1 RETURNING username`,
+			id, imp).Scan(&admin)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO impersonation_log (admin, username, event) VALUES ($1, $2, 'start')`,
+			admin, imp.Username)
+		return err
+	})
+}
+
+func (r *sessionsRepository) StopImpersonation(ctx context.Context, id model.ID) error {
+	_, err := r.pool.Exec(ctx, `
+		WITH cur AS (
+			SELECT id, username, impersonating FROM sessions
+			WHERE id = $1 AND impersonating IS NOT NULL
+			FOR UPDATE
+		), stopped AS (
+			UPDATE sessions SET impersonating = NULL WHERE id IN (SELECT id FROM cur)
+		)
+		INSERT INTO impersonation_log (admin, username, event)
+		SELECT username, impersonating->>'Username', 'stop' FROM cur`,
+		id)
+	return err
+}
+
+func (r *sessionsRepository) ListImpersonationEvents(ctx context.Context, limit int) ([]model.ImpersonationEvent, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT at, admin, username, event FROM impersonation_log
+		ORDER BY id DESC LIMIT $1`,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[model.ImpersonationEvent])
+}
+
+// deleteSessions deletes the sessions matching cond, stopping their
+// impersonations, and returns how many were deleted.
+func (r *sessionsRepository) deleteSessions(ctx context.Context, cond string, args ...any) (int64, error) {
+	var n int64
+	err := r.pool.QueryRow(ctx, `
+		WITH deleted AS (
+			DELETE FROM sessions WHERE `+cond+`
+			RETURNING username, impersonating
+		), stopped AS (
+			INSERT INTO impersonation_log (admin, username, event)
+			SELECT username, impersonating->>'Username', 'stop' FROM deleted
+			WHERE impersonating IS NOT NULL
+		)
+		SELECT count(*) FROM deleted`,
+		args...).Scan(&n)
+	return n, err
+}
+
+func (r *sessionsRepository) DeleteSession(ctx context.Context, id model.ID) error {
+	n, err := r.deleteSessions(ctx, `id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+func (r *sessionsRepository) DeleteProviderSessions(ctx context.Context, subject, providerSessionID string) error {
+	if subject == "" && providerSessionID == "" {
+		return model.ErrInvalid
+	}
+	_, err := r.deleteSessions(ctx, `($1 = '' OR subject = $1) AND ($2 = '' OR provider_session_id = $2)`,
+		subject, providerSessionID)
+	return err
+}
+
+func (r *sessionsRepository) DeleteExpiredSessions(ctx context.Context, idle, absolute time.Time) error {
+	_, err := r.deleteSessions(ctx, `last_seen_at < $1 OR created_at < $2`, idle, absolute)
+	return err
+}
@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+type usersRepository struct {
+	pool *pgxpool.Pool
+}
+
+func (r *usersRepository) Languages(ctx context.Context, usernames []string) (map[string]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT username, language FROM user_settings
+		WHERE username = ANY($1) AND language <> ''`,
+		usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	langs := make(map[string]string)
+	var username, lang string
+	_, err = pgx.ForEachRow(rows, []any{&username, &lang}, func() error {
+		langs[username] = lang
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return langs, nil
+}
+
+func (r *usersRepository) SetLanguage(ctx context.Context, username, lang string) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_settings (username, language)
+		VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET language = $2`,
+		username, lang)
+	return err
+}
+
+func (r *usersRepository) Emails(ctx context.Context, usernames []string) (map[string]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT username, email FROM user_settings
+		WHERE username = ANY($1) AND email <> ''`,
+		usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make(map[string]string)
+	var username, email string
+	_, err = pgx.ForEachRow(rows, []any{&username, &email}, func() error {
+		emails[username] = email
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+func (r *usersRepository) SetEmail(ctx context.Context, username, email string) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_settings (username, email)
+		VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET email = $2`,
+		username, email)
+	return err
+}
+
+func (r *usersRepository) Groups(ctx context.Context, usernames []string) (map[string][]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT username, groups FROM user_settings
+		WHERE username = ANY($1)`,
+		usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	var (
+		username string
+		gs       []string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&username, &gs}, func() error {
+		groups[username] = gs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (r *usersRepository) SetGroups(ctx context.Context, username string, groups []string) error {
+	if groups == nil {
+		groups = []string{}
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_settings (username, groups)
+		VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET groups = $2`,
+		username, groups)
+	return err
+}
+
+func (r *usersRepository) NotificationPreferences(ctx context.Context, usernames []string) (map[string]*model.NotificationPreferences, error) {
+	prefs := make(map[string]*model.NotificationPreferences)
+	get := func(username string) *model.NotificationPreferences {
+		if prefs[username] == nil {
+			prefs[username] = &model.NotificationPreferences{
+				Enabled: make(map[model.NotificationEvent]map[model.NotificationChannel]bool),
+			}
+		}
+		return prefs[username]
+	}
+
+	// expired pending addresses are left out
+	rows, err := r.pool.Query(ctx, `
+		SELECT username, notify_email,
+			CASE WHEN notify_email_expires_at > now() THEN notify_email_pending ELSE '' END AS pending
+		FROM user_settings
+		WHERE username = ANY($1) AND (notify_email <> '' OR notify_email_expires_at > now())`,
+		usernames)
+	if err != nil {
+		return nil, err
+	}
+	var username, email, pending string
+	_, err = pgx.ForEachRow(rows, []any{&username, &email, &pending}, func() error {
+		p := get(username)
+		p.Email, p.PendingEmail = email, pending
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT username, event, channel, enabled FROM notification_preferences
+		WHERE username = ANY($1)`,
+		usernames)
+	if err != nil {
+		return nil, err
+	}
+	var event model.NotificationEvent
+	var ch model.NotificationChannel
+	var enabled bool
+	_, err = pgx.ForEachRow(rows, []any{&username, &event, &ch, &enabled}, func() error {
+		p := get(username)
+		if p.Enabled[event] == nil {
+			p.Enabled[event] = make(map[model.NotificationChannel]bool)
+		}
+		p.Enabled[event][ch] = enabled
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+func (r *usersRepository) RequestNotificationEmail(ctx context.Context, username, email, token string, expires time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_settings (username, notify_email_pending, notify_email_token, notify_email_expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username) DO UPDATE
+		SET notify_email_pending = $2, notify_email_token = $3, notify_email_expires_at = $4`,
+		username, email, hashToken(token), expires)
+	return err
+}
+
+func (r *usersRepository) ConfirmNotificationEmail(ctx context.Context, username, token string, now time.Time) (string, error) {
+	var email string
+	err := r.pool.QueryRow(ctx, `
+		UPDATE user_settings
+		SET notify_email = notify_email_pending, notify_email_pending = '',
+			notify_email_token = NULL, notify_email_expires_at = NULL
+		WHERE username = $1 AND notify_email_token = $2 AND notify_email_expires_at > $3
+		RETURNING notify_email`,
+		username, hashToken(token), now).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", model.ErrNotFound
+	}
+	return email, err
+}
+
+func (r *usersRepository) SetNotificationPreferences(ctx context.Context, username string, prefs *model.NotificationPreferences) error {
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO user_settings (username, notify_email)
+			VALUES ($1, $2)
+			ON CONFLICT (username) DO UPDATE SET notify_email = $2`,
+			username, prefs.Email)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM notification_preferences WHERE username = $1`, username)
+		if err != nil {
+			return err
+		}
+		for event, chs := range prefs.Enabled {
+			for ch, enabled := range chs {
+				_, err = tx.Exec(ctx, `
+					INSERT INTO notification_preferences (username, event, channel, enabled)
+					VALUES ($1, $2, $3, $4)`,
+					username, event, ch, enabled)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"slices"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 
@@ -21,7 +22,7 @@ func queryWorkspace(ctx context.Context, tx pgx.Tx, id model.ID) (*model.Workspa
 }
 
 func queryWorkspaces(ctx context.Context, tx pgx.Tx, ids []model.ID) ([]*model.Workspace, error) {
-	rows, err := tx.Query(ctx, `SELECT id, created, enabled, nodegroup, userdata FROM workspaces WHERE id = ANY($1) ORDER BY id`, ids)
+	rows, err := tx.Query(ctx, `SELECT id, created, enabled, nodegroup, userdata, cluster FROM workspaces WHERE id = ANY($1) ORDER BY id`, ids)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +50,9 @@ func queryWorkspaces(ctx context.Context, tx pgx.Tx, ids []model.ID) ([]*model.W
 	if err := fillRequests(ctx, tx, ids, wsind); err != nil {
 		return nil, err
 	}
+	if err := fillProvisioning(ctx, tx, ids, wsind); err != nil {
+		return nil, err
+	}
 
 	return wss, nil
 }
@@ -96,23 +100,46 @@ func fillUsers(ctx context.Context, tx pgx.Tx, idx []model.ID, wsind map[model.I
 }
 
 func fillRequests(ctx context.Context, tx pgx.Tx, idx []model.ID, wsind map[model.ID]*model.Workspace) error {
-	rows, err := tx.Query(ctx, `SELECT workspace_id, data FROM workspaces_updaterequests WHERE workspace_id = ANY($1)`, idx)
+	rows, err := tx.Query(ctx, `SELECT workspace_id, data, requested_at FROM workspaces_updaterequests WHERE workspace_id = ANY($1)`, idx)
 	if err != nil {
 		return err
 	}
 
 	var (
-		id   model.ID
-		data string
+		id          model.ID
+		data        string
+		requestedAt time.Time
 	)
-	_, err = pgx.ForEachRow(rows, []any{&id, &data}, func() error {
+	_, err = pgx.ForEachRow(rows, []any{&id, &data, &requestedAt}, func() error {
 		upd := model.WorkspaceUpdate{}
 		if err := json.Unmarshal([]byte(data), &upd); err != nil {
 			return err
 		}
+		upd.RequestedAt = requestedAt
 		slices.Sort(upd.Users)
 		wsind[id].Request = &upd
 		return nil
 	})
 	return err
 }
+
+func fillProvisioning(ctx context.Context, tx pgx.Tx, idx []model.ID, wsind map[model.ID]*model.Workspace) error {
+	rows, err := tx.Query(ctx, `SELECT workspace_id, state, message FROM workspaces_provisioning WHERE workspace_id = ANY($1)`, idx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		id      model.ID
+		state   model.ProvisionState
+		message string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&id, &state, &message}, func() error {
+		wsind[id].Provision = model.ProvisionStatus{
+			State:   state,
+			Message: message,
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// Session is a login of a user, stored server-side. Only a random token
+// identifying it is kept in the session cookie.
+type Session struct {
+	ID ID
+
+	// The user as of the last validation against the identity provider.
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+
+	// OIDC refresh token used to re-validate the user, empty if none was
+	// issued.
+	RefreshToken string
+	// OIDC ID token, passed to the provider when logging out.
+	IDToken string
+	// The login at the provider, matched by back-channel logouts.
+	ProviderSessionID string
+
+	// Set while an admin impersonates another user in this session.
+	Impersonating *Impersonation
+
+	UserAgent string
+	RemoteIP  string
+
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ValidatedAt time.Time
+}
+
+// Impersonation is an admin viewing the site as another user, read-only.
+type Impersonation struct {
+	Username string
+	Email    string
+	Groups   []string
+
+	StartedAt time.Time
+}
+
+// ImpersonationEvent is an entry of the audit log of impersonations.
+type ImpersonationEvent struct {
+	At       time.Time
+	Admin    string
+	Username string
+	// "start" or "stop"
+	Event string
+}
+
+type SessionService interface {
+	// Create a session identified by token. Returns the session with its ID
+	// and timestamps set.
+	CreateSession(ctx context.Context, sess *Session, token string) (*Session, error)
+	// Get the session identified by token, or ErrNotFound.
+	GetSession(ctx context.Context, token string) (*Session, error)
+
+	// List the sessions of a user, most recently seen first.
+	ListUserSessions(ctx context.Context, username string) ([]*Session, error)
+	// List all sessions, most recently seen first.
+	ListAllSessions(ctx context.Context) ([]*Session, error)
+
+	// Record that the session was used at t.
+	TouchSession(ctx context.Context, id ID, t time.Time) error
+	// Claim re-validating the session, last validated at validatedAt, by
+	// setting ValidatedAt to t. Returns false if it was validated or claimed
+	// since, so that concurrent requests don't all use the refresh token.
+	ClaimRefresh(ctx context.Context, id ID, validatedAt, t time.Time) (bool, error)
+	// Replace the user and tokens of the session after re-validating them,
+	// and set ValidatedAt to t.
+	ValidateSession(ctx context.Context, sess *Session, t time.Time) error
+
+	// Start impersonating a user in the session, and record it in the audit
+	// log.
+	StartImpersonation(ctx context.Context, id ID, imp *Impersonation) error
+	// Stop impersonating in the session, if it does, and record it in the
+	// audit log. Revoking a session also stops its impersonation.
+	StopImpersonation(ctx context.Context, id ID) error
+	// List the latest entries of the impersonation audit log, most recent
+	// first.
+	ListImpersonationEvents(ctx context.Context, limit int) ([]ImpersonationEvent, error)
+
+	// Revoke the session. Returns ErrNotFound if it does not exist.
+	DeleteSession(ctx context.Context, id ID) error
+	// Revoke the sessions of subject, or only the one of providerSessionID if
+	// set, after the user logged out of the provider. Either may be empty.
+	DeleteProviderSessions(ctx context.Context, subject, providerSessionID string) error
+	// Revoke sessions last seen before idle, or created before absolute.
+	DeleteExpiredSessions(ctx context.Context, idle, absolute time.Time) error
+}
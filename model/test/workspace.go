@@ -11,6 +11,12 @@ import (
 	"github.com/bacchus-snu/sgs/model"
 )
 
+// workspaceOpts compare workspaces, except for the time of requests.
+var workspaceOpts = cmp.Options{
+	cmpopts.EquateEmpty(),
+	cmpopts.IgnoreFields(model.WorkspaceUpdate{}, "RequestedAt"),
+}
+
 func TestWorkspace(t *testing.T, wsf func() model.WorkspaceService) {
 	type testScenario func(t *testing.T, wsSvc model.WorkspaceService)
 	tests := map[string]testScenario{
@@ -65,6 +71,7 @@ func TestWorkspace(t *testing.T, wsf func() model.WorkspaceService) {
 				want.Users[i] = model.WorkspaceUser{Username: u, Email: existingEmails[u]}
 			}
 			want.Request = nil
+			want.Provision = model.ProvisionStatus{State: model.ProvisionPending}
 			testWorkspaceUpdate(t, wsSvc, &changes, &want, nil)
 
 			testWorkspaceListAll(t, wsSvc, []*model.Workspace{&want})
@@ -271,6 +278,7 @@ func TestWorkspace(t *testing.T, wsf func() model.WorkspaceService) {
 
 			ws.Created = true
 			ws.Enabled = true
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
 			testWorkspaceUpdate(t, wsSvc, &model.WorkspaceUpdate{
 				WorkspaceID: ws.ID,
 				Enabled:     true,
@@ -286,6 +294,153 @@ func TestWorkspace(t *testing.T, wsf func() model.WorkspaceService) {
 				Users:       model.Usernames(ws.Users),
 			}, &ws, nil)
 		},
+
+		"provisioning": func(t *testing.T, wsSvc model.WorkspaceService) {
+			ws := model.Workspace{
+				Nodegroup: model.NodegroupUndergraduate,
+				Users:     []model.WorkspaceUser{{Username: "user1"}},
+			}
+			ws.ID = testWorkspaceCreate(t, wsSvc, &ws, nil)
+
+			enable := model.WorkspaceUpdate{
+				WorkspaceID: ws.ID,
+				Enabled:     true,
+				Nodegroup:   ws.Nodegroup,
+				Users:       model.Usernames(ws.Users),
+			}
+			ws.Created = true
+			ws.Enabled = true
+			ws.Request = nil
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+			testWorkspaceUpdate(t, wsSvc, &enable, &ws, nil)
+
+			// failures are recorded, but not announced
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionFailed, Message: "oops"}
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: ws.Provision,
+				12345: {State: model.ProvisionReady}, // unknown, ignored
+			}, nil, nil)
+			testWorkspaceGet(t, wsSvc, ws.ID, &ws)
+
+			// first time ready after enabling is announced
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionReady}
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: ws.Provision,
+			}, []*model.Workspace{&ws}, nil)
+			testWorkspaceGet(t, wsSvc, ws.ID, &ws)
+
+			// but only once
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: ws.Provision,
+			}, nil, nil)
+
+			// further updates of enabled workspaces are not announced
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+			testWorkspaceUpdate(t, wsSvc, &enable, &ws, nil)
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionReady}
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: ws.Provision,
+			}, nil, nil)
+
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: {State: "invalid"},
+			}, nil, model.ErrInvalid)
+		},
+
+		"revision": func(t *testing.T, wsSvc model.WorkspaceService) {
+			rev := testWorkspaceRevision(t, wsSvc, -1)
+
+			ws := model.Workspace{
+				Nodegroup: model.NodegroupUndergraduate,
+				Users:     []model.WorkspaceUser{{Username: "user1"}},
+			}
+			ws.ID = testWorkspaceCreate(t, wsSvc, &ws, nil)
+			ws.Request = ws.InitialRequest()
+			rev = testWorkspaceRevision(t, wsSvc, rev)
+
+			// reads and failed writes don't change the revision
+			testWorkspaceListAll(t, wsSvc, []*model.Workspace{&ws})
+			testWorkspaceDelete(t, wsSvc, 12345, model.ErrNotFound)
+			if got := testWorkspaceRevision(t, wsSvc, -1); got != rev {
+				t.Fatalf("Revision() = %d; want %d", got, rev)
+			}
+
+			upd := model.WorkspaceUpdate{
+				WorkspaceID: ws.ID,
+				ByUser:      "user1",
+				Enabled:     true,
+				Nodegroup:   ws.Nodegroup,
+				Users:       []string{"user1", "user2"},
+			}
+			if _, err := wsSvc.RequestUpdateWorkspace(context.Background(), &upd); err != nil {
+				t.Fatalf("RequestUpdateWorkspace() = %v; want nil", err)
+			}
+			rev = testWorkspaceRevision(t, wsSvc, rev)
+
+			if _, err := wsSvc.UpdateWorkspace(context.Background(), &upd); err != nil {
+				t.Fatalf("UpdateWorkspace() = %v; want nil", err)
+			}
+			rev = testWorkspaceRevision(t, wsSvc, rev)
+
+			// provisioning results don't count as changes
+			if _, err := wsSvc.ReportProvisioning(context.Background(), map[model.ID]model.ProvisionStatus{
+				ws.ID: {State: model.ProvisionReady},
+			}); err != nil {
+				t.Fatalf("ReportProvisioning() = %v; want nil", err)
+			}
+			if got := testWorkspaceRevision(t, wsSvc, -1); got != rev {
+				t.Fatalf("Revision() = %d; want %d", got, rev)
+			}
+
+			if err := wsSvc.DeclineInvitation(context.Background(), ws.ID, "user2"); err != nil {
+				t.Fatalf("DeclineInvitation() = %v; want nil", err)
+			}
+			rev = testWorkspaceRevision(t, wsSvc, rev)
+
+			testWorkspaceDelete(t, wsSvc, ws.ID, nil)
+			testWorkspaceRevision(t, wsSvc, rev)
+		},
+
+		"cluster": func(t *testing.T, wsSvc model.WorkspaceService) {
+			ws := model.Workspace{
+				Nodegroup: model.NodegroupUndergraduate,
+				Users:     []model.WorkspaceUser{{Username: "user1"}},
+			}
+			ws.ID = testWorkspaceCreate(t, wsSvc, &ws, nil)
+			ws.Request = ws.InitialRequest()
+
+			// pending workspaces may be assigned, too
+			ws.Cluster = "cocktail"
+			testWorkspaceAssignCluster(t, wsSvc, ws.ID, "cocktail", &ws, nil)
+			testWorkspaceGet(t, wsSvc, ws.ID, &ws)
+
+			enable := model.WorkspaceUpdate{
+				WorkspaceID: ws.ID,
+				Enabled:     true,
+				Nodegroup:   ws.Nodegroup,
+				Users:       model.Usernames(ws.Users),
+			}
+			ws.Created = true
+			ws.Enabled = true
+			ws.Request = nil
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+			testWorkspaceUpdate(t, wsSvc, &enable, &ws, nil)
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionReady}
+			testWorkspaceReportProvisioning(t, wsSvc, map[model.ID]model.ProvisionStatus{
+				ws.ID: ws.Provision,
+			}, []*model.Workspace{&ws}, nil)
+
+			// unchanged, still ready
+			testWorkspaceAssignCluster(t, wsSvc, ws.ID, "cocktail", &ws, nil)
+
+			// moving resets provisioning
+			ws.Cluster = ""
+			ws.Provision = model.ProvisionStatus{State: model.ProvisionPending}
+			testWorkspaceAssignCluster(t, wsSvc, ws.ID, "", &ws, nil)
+			testWorkspaceGet(t, wsSvc, ws.ID, &ws)
+
+			testWorkspaceAssignCluster(t, wsSvc, 12345, "cocktail", nil, model.ErrNotFound)
+		},
 	}
 
 	for name, test := range tests {
@@ -316,9 +471,12 @@ func testWorkspaceCreate(t *testing.T, wsSvc model.WorkspaceService, ws *model.W
 		want.Users[0].Email = creatorEmail
 	}
 	want.Request = want.InitialRequest()
-	if diff := cmp.Diff(got, &want, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(got, &want, workspaceOpts); diff != "" {
 		t.Fatalf("CreateWorkspace(%#v) = mismatch\n%s", ws, diff)
 	}
+	if got.Request.RequestedAt.IsZero() {
+		t.Fatalf("CreateWorkspace(%#v) request has no time", ws)
+	}
 
 	// Update the original workspace for subsequent test assertions
 	if len(ws.Users) > 0 {
@@ -334,7 +492,7 @@ func testWorkspaceListAll(t *testing.T, wsSvc model.WorkspaceService, expect []*
 	if err != nil {
 		t.Fatalf("ListAllWorkspaces() = %v; want nil", err)
 	}
-	if diff := cmp.Diff(wss, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(wss, expect, workspaceOpts); diff != "" {
 		t.Fatalf("ListAllWorkspaces() = mismatch\n%s", diff)
 	}
 }
@@ -345,7 +503,7 @@ func testWorkspaceListUser(t *testing.T, wsSvc model.WorkspaceService, user stri
 	if err != nil {
 		t.Fatalf("ListUserWorkspaces(%q) = %v; want nil", user, err)
 	}
-	if diff := cmp.Diff(wss, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(wss, expect, workspaceOpts); diff != "" {
 		t.Fatalf("ListUserWorkspaces(%q) = mismatch\n%s", user, diff)
 	}
 }
@@ -356,7 +514,7 @@ func testWorkspaceListCreated(t *testing.T, wsSvc model.WorkspaceService, expect
 	if err != nil {
 		t.Fatalf("ListCreatedWorkspaces() = %v; want nil", err)
 	}
-	if diff := cmp.Diff(wss, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(wss, expect, workspaceOpts); diff != "" {
 		t.Fatalf("ListCreatedWorkspaces() = mismatch\n%s", diff)
 	}
 }
@@ -370,7 +528,7 @@ func testWorkspaceGet(t *testing.T, wsSvc model.WorkspaceService, id model.ID, e
 	if expect == nil && !errors.Is(err, model.ErrNotFound) {
 		t.Fatalf("GetWorkspace(%d) = %v; want %v", id, err, model.ErrNotFound)
 	}
-	if diff := cmp.Diff(ws, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(ws, expect, workspaceOpts); diff != "" {
 		t.Fatalf("GetWorkspace(%d) = mismatch\n%s", id, diff)
 	}
 }
@@ -384,7 +542,7 @@ func testWorkspaceGetUser(t *testing.T, wsSvc model.WorkspaceService, id model.I
 	if expect == nil && !errors.Is(err, model.ErrNotFound) {
 		t.Fatalf("GetUserWorkspace(%d, %q) = %v; want %v", id, user, err, model.ErrNotFound)
 	}
-	if diff := cmp.Diff(ws, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(ws, expect, workspaceOpts); diff != "" {
 		t.Fatalf("GetUserWorkspace(%d, %q) = mismatch\n%s", id, user, diff)
 	}
 }
@@ -395,7 +553,7 @@ func testWorkspaceUpdate(t *testing.T, wsSvc model.WorkspaceService, upd *model.
 	if !errors.Is(err, expErr) {
 		t.Fatalf("UpdateWorkspace(%#v) = %v; want %v", upd, err, expErr)
 	}
-	if diff := cmp.Diff(ws, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(ws, expect, workspaceOpts); diff != "" {
 		t.Fatalf("UpdateWorkspace(%#v) = mismatch\n%s", upd, diff)
 	}
 }
@@ -406,7 +564,7 @@ func testWorkspaceRequestUpdate(t *testing.T, wsSvc model.WorkspaceService, upd
 	if !errors.Is(err, expErr) {
 		t.Fatalf("RequestUpdateWorkspace(%#v) = %v; want %v", upd, err, expErr)
 	}
-	if diff := cmp.Diff(ws, expect, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(ws, expect, workspaceOpts); diff != "" {
 		t.Fatalf("RequestUpdateWorkspace(%#v) = mismatch\n%s", upd, diff)
 	}
 }
@@ -418,3 +576,39 @@ func testWorkspaceDelete(t *testing.T, wsSvc model.WorkspaceService, id model.ID
 		t.Fatalf("DeleteWorkspace(%d) = %v; want %v", id, err, expErr)
 	}
 }
+
+func testWorkspaceReportProvisioning(t *testing.T, wsSvc model.WorkspaceService, statuses map[model.ID]model.ProvisionStatus, expect []*model.Workspace, expErr error) {
+	t.Helper()
+	wss, err := wsSvc.ReportProvisioning(context.Background(), statuses)
+	if !errors.Is(err, expErr) {
+		t.Fatalf("ReportProvisioning(%#v) = %v; want %v", statuses, err, expErr)
+	}
+	if diff := cmp.Diff(wss, expect, workspaceOpts); diff != "" {
+		t.Fatalf("ReportProvisioning(%#v) = mismatch\n%s", statuses, diff)
+	}
+}
+
+func testWorkspaceAssignCluster(t *testing.T, wsSvc model.WorkspaceService, id model.ID, cluster string, expect *model.Workspace, expErr error) {
+	t.Helper()
+	ws, err := wsSvc.AssignCluster(context.Background(), id, cluster)
+	if !errors.Is(err, expErr) {
+		t.Fatalf("AssignCluster(%d, %q) = %v; want %v", id, cluster, err, expErr)
+	}
+	if diff := cmp.Diff(ws, expect, workspaceOpts); diff != "" {
+		t.Fatalf("AssignCluster(%d, %q) = mismatch\n%s", id, cluster, diff)
+	}
+}
+
+// testWorkspaceRevision returns the current revision, which must be greater
+// than prev.
+func testWorkspaceRevision(t *testing.T, wsSvc model.WorkspaceService, prev int64) int64 {
+	t.Helper()
+	rev, err := wsSvc.Revision(context.Background())
+	if err != nil {
+		t.Fatalf("Revision() = %v; want nil", err)
+	}
+	if rev <= prev {
+		t.Fatalf("Revision() = %d; want > %d", rev, prev)
+	}
+	return rev
+}
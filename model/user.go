@@ -0,0 +1,74 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// NotificationChannel is a way users are notified.
+type NotificationChannel string
+
+const (
+	ChannelEmail NotificationChannel = "email"
+)
+
+var NotificationChannels = []NotificationChannel{ChannelEmail}
+
+// NotificationPreferences are chosen by users, for the UserEvents.
+type NotificationPreferences struct {
+	// Emails are sent here instead of the user's address, if set.
+	Email string
+	// An address awaiting confirmation, which replaces Email once the user
+	// follows the link sent to it.
+	PendingEmail string
+	// Whether the user is notified of an event through a channel. Users are
+	// notified of events missing here.
+	Enabled map[NotificationEvent]map[NotificationChannel]bool
+}
+
+// Wants reports whether the user is notified of event through ch.
+func (p *NotificationPreferences) Wants(event NotificationEvent, ch NotificationChannel) bool {
+	if p == nil {
+		return true
+	}
+	if enabled, ok := p.Enabled[event][ch]; ok {
+		return enabled
+	}
+	return true
+}
+
+// UserService stores per-user settings, of users who logged in at least once.
+type UserService interface {
+	// Languages returns the preferred language of those of the users who have
+	// one.
+	Languages(ctx context.Context, usernames []string) (map[string]string, error)
+	// SetLanguage sets the preferred language of the user, eg. "en" or "ko".
+	SetLanguage(ctx context.Context, username, lang string) error
+
+	// Emails returns the address of those of the users who logged in with
+	// one.
+	Emails(ctx context.Context, usernames []string) (map[string]string, error)
+	// SetEmail records the address the user logged in with.
+	SetEmail(ctx context.Context, username, email string) error
+
+	// Groups returns the groups of the users, as of their last login or
+	// session refresh.
+	Groups(ctx context.Context, usernames []string) (map[string][]string, error)
+	// SetGroups records the groups the user logged in or refreshed their
+	// session with.
+	SetGroups(ctx context.Context, username string, groups []string) error
+
+	// NotificationPreferences returns the preferences of those of the users
+	// who have set them.
+	NotificationPreferences(ctx context.Context, usernames []string) (map[string]*NotificationPreferences, error)
+	// SetNotificationPreferences replaces the preferences of the user, but
+	// not PendingEmail. Email must have been confirmed, or be empty.
+	SetNotificationPreferences(ctx context.Context, username string, prefs *NotificationPreferences) error
+	// RequestNotificationEmail records email as the pending address of the
+	// user, to be confirmed with token before expires.
+	RequestNotificationEmail(ctx context.Context, username, email, token string, expires time.Time) error
+	// ConfirmNotificationEmail makes the pending address of the user the one
+	// they are emailed at, if token is theirs and not expired at now. Returns
+	// the address, or ErrNotFound.
+	ConfirmNotificationEmail(ctx context.Context, username, token string, now time.Time) (string, error)
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"context"
+	"slices"
+	"time"
 )
 
 // WorkspaceUser represents a user in a workspace with their acceptance status.
@@ -37,6 +39,14 @@ type Workspace struct {
 	Users  []WorkspaceUser
 
 	Request *WorkspaceUpdate
+
+	// Cluster assigned by administrators. Empty places the workspace in the
+	// default cluster of its nodegroup.
+	Cluster string
+
+	// Provisioning status of external resources, as reported by the worker.
+	// Zero for workspaces that were never created.
+	Provision ProvisionStatus
 }
 
 func (ws Workspace) Valid() bool {
@@ -82,6 +92,34 @@ func (ws *Workspace) InitialRequest() *WorkspaceUpdate {
 	}
 }
 
+// Requested returns the workspace with its pending request applied, or ws
+// itself if there is none.
+func (ws *Workspace) Requested() *Workspace {
+	if ws.Request == nil {
+		return ws
+	}
+
+	newWS := *ws
+	newWS.Enabled = ws.Request.Enabled
+	newWS.Nodegroup = ws.Request.Nodegroup
+	newWS.Userdata = ws.Request.Userdata
+	newWS.Quotas = ws.Request.Quotas
+
+	// preserve the email of existing users, empty for new ones
+	existingUsers := make(map[string]string, len(ws.Users))
+	for _, u := range ws.Users {
+		existingUsers[u.Username] = u.Email
+	}
+	newWS.Users = make([]WorkspaceUser, len(ws.Request.Users))
+	for i, username := range ws.Request.Users {
+		newWS.Users[i] = WorkspaceUser{
+			Username: username,
+			Email:    existingUsers[username],
+		}
+	}
+	return &newWS
+}
+
 type WorkspaceUpdate struct {
 	WorkspaceID ID
 	ByUser      string
@@ -92,6 +130,9 @@ type WorkspaceUpdate struct {
 
 	Quotas map[Resource]uint64
 	Users  []string
+
+	// When the request was filed, zero until then.
+	RequestedAt time.Time `json:",omitzero"`
 }
 
 func (ws WorkspaceUpdate) Valid() bool {
@@ -116,6 +157,30 @@ func (ws WorkspaceUpdate) Valid() bool {
 	return true
 }
 
+// ProvisionState is the state of a workspace's external resources (namespace,
+// registry, ...), as last reported by the worker.
+type ProvisionState string
+
+const (
+	// Changes were made, but the worker has not reported back yet.
+	ProvisionPending ProvisionState = "pending"
+	ProvisionReady   ProvisionState = "ready"
+	ProvisionFailed  ProvisionState = "failed"
+)
+
+func (s ProvisionState) Valid() bool {
+	switch s {
+	case ProvisionPending, ProvisionReady, ProvisionFailed:
+		return true
+	}
+	return false
+}
+
+type ProvisionStatus struct {
+	State   ProvisionState
+	Message string
+}
+
 type Resource string
 
 const (
@@ -165,7 +230,8 @@ func (n Nodegroup) Valid() bool {
 }
 
 type WorkspaceService interface {
-	// Accept user-provided fields only.
+	// Accept user-provided fields only. Subscribed admins are notified of the
+	// request.
 	CreateWorkspace(ctx context.Context, ws *Workspace, creatorEmail string) (*Workspace, error)
 
 	// Full scan every workspace.
@@ -181,9 +247,12 @@ type WorkspaceService interface {
 	// Return ErrNotFound if not owned.
 	GetUserWorkspace(ctx context.Context, id ID, user string) (*Workspace, error)
 
-	// Immediately apply any changes, for admins.
+	// Immediately apply any changes, for admins. Resets the provisioning state
+	// to pending. Users are notified if the workspace was disabled, or of
+	// changed members.
 	UpdateWorkspace(ctx context.Context, upd *WorkspaceUpdate) (*Workspace, error)
-	// Requetst an update, for uesrs. Ignore admin-controlled fields.
+	// Requetst an update, for uesrs. Ignore admin-controlled fields. Users of
+	// the workspace are notified of the request.
 	RequestUpdateWorkspace(ctx context.Context, upd *WorkspaceUpdate) (*Workspace, error)
 
 	// Accept a workspace invitation (sets email for the user).
@@ -191,23 +260,98 @@ type WorkspaceService interface {
 	// Decline a workspace invitation (removes user from workspace).
 	DeclineInvitation(ctx context.Context, workspaceID ID, username string) error
 
+	// Record provisioning results reported by the worker. Unknown IDs are
+	// ignored. Returns the workspaces that became ready for the first time
+	// since they were enabled, whose users are notified exactly once. Users are
+	// notified of failures as well, unless the last report failed too.
+	ReportProvisioning(ctx context.Context, statuses map[ID]ProvisionStatus) ([]*Workspace, error)
+
 	DeleteWorkspace(ctx context.Context, id ID) error
+
+	// Assign the workspace to a cluster, empty for the default of its
+	// nodegroup. Resets provisioning to pending if the cluster changed.
+	AssignCluster(ctx context.Context, id ID, cluster string) (*Workspace, error)
+
+	// Revision is bumped by every call that modifies workspaces, except
+	// ReportProvisioning. Used to detect whether anything changed.
+	Revision(ctx context.Context) (int64, error)
+}
+
+// SubscriptionMode is how subscribed admins are emailed.
+type SubscriptionMode string
+
+const (
+	// Emailed of every event as it happens.
+	ModeImmediate SubscriptionMode = "immediate"
+	// Emailed a daily digest instead.
+	ModeDigest SubscriptionMode = "digest"
+)
+
+var SubscriptionModes = []SubscriptionMode{ModeImmediate, ModeDigest}
+
+// AdminEvents are the events admins subscribe to.
+var AdminEvents = []NotificationEvent{
+	EventWorkspaceRequested,
+	EventWorkspaceUpdateRequested,
+	EventWorkspaceSyncFailed,
+	EventRequestStale,
 }
 
 // Subscriber represents an admin subscribed to email notifications.
 type Subscriber struct {
 	Username string
 	Email    string
+	Mode     SubscriptionMode
+	// Only events of workspaces in Nodegroups, and of Events, are sent. Empty
+	// sends all of them.
+	Nodegroups []Nodegroup
+	Events     []NotificationEvent
+}
+
+func (s Subscriber) Valid() bool {
+	if !slices.Contains(SubscriptionModes, s.Mode) {
+		return false
+	}
+	for _, ng := range s.Nodegroups {
+		if !ng.Valid() {
+			return false
+		}
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(AdminEvents, event) {
+			return false
+		}
+	}
+	return true
+}
+
+// Wants reports whether the subscriber is interested in event of a workspace
+// in any of nodegroups.
+func (s Subscriber) Wants(event NotificationEvent, nodegroups ...Nodegroup) bool {
+	if len(s.Events) > 0 && !slices.Contains(s.Events, event) {
+		return false
+	}
+	if len(s.Nodegroups) == 0 {
+		return true
+	}
+	for _, ng := range nodegroups {
+		if slices.Contains(s.Nodegroups, ng) {
+			return true
+		}
+	}
+	return false
 }
 
 // MailingListService manages admin notification subscriptions.
 type MailingListService interface {
-	// Subscribe adds an admin to the mailing list.
-	Subscribe(ctx context.Context, username, email string) error
+	// Subscribe adds an admin to the mailing list, or updates their
+	// subscription.
+	Subscribe(ctx context.Context, sub *Subscriber) error
 	// Unsubscribe removes an admin from the mailing list.
 	Unsubscribe(ctx context.Context, username string) error
-	// IsSubscribed checks if an admin is subscribed.
-	IsSubscribed(ctx context.Context, username string) (bool, error)
+	// GetSubscription returns the subscription of an admin, or ErrNotFound
+	// if they are not subscribed.
+	GetSubscription(ctx context.Context, username string) (*Subscriber, error)
 	// ListSubscribers returns all subscribed admins.
 	ListSubscribers(ctx context.Context) ([]Subscriber, error)
 }
@@ -4,76 +4,162 @@ import (
 	"context"
 	"encoding/gob"
 	"errors"
-	"slices"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/secret"
 )
 
-// We expect returned values to be stored in gorilla sessions, so they must be
+// We expect verifiers to be stored in gorilla sessions, so they must be
 // registered.
 func init() {
-	gob.Register(&User{})
 	gob.Register(&oidcVerififer{})
 }
 
 type User struct {
+	// Subject identifies the user at the provider.
+	Subject  string   `json:"sub"`
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Groups   []string `json:"groups"`
 }
 
-func (u *User) IsAdmin() bool {
-	return slices.Contains(u.Groups, "bacchus")
+type Config struct {
+	Issuer       string `mapstructure:"issuer"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// ClientSecretFile is read instead of ClientSecret, if set.
+	ClientSecretFile string   `mapstructure:"client_secret_file"`
+	Scopes           []string `mapstructure:"scopes"`
+	RedirectURL      string   `mapstructure:"redirect_url"`
+	// Users are sent here by the provider after logging out, if set. Must be
+	// registered with the provider.
+	PostLogoutRedirectURL string `mapstructure:"post_logout_redirect_url"`
+
+	// Dev logs in any user through a form, without an OIDC provider. For
+	// local development only, see DevService.
+	Dev bool `mapstructure:"dev"`
+
+	// Members of these groups have the respective admin roles, see Policy.
+	SuperadminGroups []string         `mapstructure:"superadmin_groups"`
+	ReviewerGroups   []string         `mapstructure:"reviewer_groups"`
+	NodegroupAdmins  []NodegroupAdmin `mapstructure:"nodegroup_admins"`
 }
 
-type Config struct {
-	Issuer       string   `mapstructure:"issuer"`
-	ClientID     string   `mapstructure:"client_id"`
-	ClientSecret string   `mapstructure:"client_secret"`
-	Scopes       []string `mapstructure:"scopes"`
-	RedirectURL  string   `mapstructure:"redirect_url"`
+// NodegroupAdmin grants members of Group admin rights over workspaces in
+// Nodegroups.
+type NodegroupAdmin struct {
+	Group      string   `mapstructure:"group"`
+	Nodegroups []string `mapstructure:"nodegroups"`
 }
 
 func (c *Config) Bind() {
+	viper.BindEnv("auth.dev", "SGS_AUTH_DEV")
 	viper.BindEnv("auth.issuer", "SGS_AUTH_ISSUER")
 	viper.BindEnv("auth.client_id", "SGS_AUTH_CLIENT_ID")
 	viper.BindEnv("auth.client_secret", "SGS_AUTH_CLIENT_SECRET")
+	viper.BindEnv("auth.client_secret_file", "SGS_AUTH_CLIENT_SECRET_FILE")
 	viper.BindEnv("auth.scopes", "SGS_AUTH_SCOPES")
 	viper.BindEnv("auth.redirect_url", "SGS_AUTH_REDIRECT_URL")
+	viper.BindEnv("auth.post_logout_redirect_url", "SGS_AUTH_POST_LOGOUT_REDIRECT_URL")
+	viper.BindEnv("auth.superadmin_groups", "SGS_AUTH_SUPERADMIN_GROUPS")
+	viper.BindEnv("auth.reviewer_groups", "SGS_AUTH_REVIEWER_GROUPS")
+	// JSON-encoded list of nodegroup admins
+	viper.BindEnv("auth.nodegroup_admins", "SGS_AUTH_NODEGROUP_ADMINS")
 
 	viper.SetDefault("auth.scopes", []string{"openid", "profile", "email"})
+	viper.SetDefault("auth.superadmin_groups", []string{"bacchus"})
 }
 
 func (c *Config) Validate() error {
 	var err error
 
-	if c.Issuer == "" {
-		err = errors.Join(err, errors.New("issuer is required"))
+	if err1 := secret.Load(&c.ClientSecret, c.ClientSecretFile); err1 != nil {
+		err = errors.Join(err, fmt.Errorf("client_secret_file: %w", err1))
 	}
-	if c.ClientID == "" {
-		err = errors.Join(err, errors.New("client_id is required"))
+
+	if !c.Dev {
+		if c.Issuer == "" {
+			err = errors.Join(err, errors.New("issuer is required"))
+		}
+		if c.ClientID == "" {
+			err = errors.Join(err, errors.New("client_id is required"))
+		}
+		if c.RedirectURL == "" {
+			err = errors.Join(err, errors.New("redirect_url is required"))
+		}
 	}
-	if c.RedirectURL == "" {
-		err = errors.Join(err, errors.New("redirect_url is required"))
+	for i, na := range c.NodegroupAdmins {
+		if na.Group == "" {
+			err = errors.Join(err, fmt.Errorf("nodegroup_admins[%d]: group is required", i))
+		}
+		for _, ng := range na.Nodegroups {
+			if !model.Nodegroup(ng).Valid() {
+				err = errors.Join(err, fmt.Errorf("nodegroup_admins[%d]: unknown nodegroup %q", i, ng))
+			}
+		}
 	}
 
 	return err
 }
 
+// Redacted returns a copy of c with secrets redacted.
+func (c Config) Redacted() Config {
+	if c.ClientSecret != "" {
+		c.ClientSecret = "REDACTED"
+	}
+	return c
+}
+
+// Tokens are the tokens of a login kept with the session.
+type Tokens struct {
+	// Empty if the provider did not issue one.
+	RefreshToken string
+	// The raw ID token, passed as id_token_hint when logging out.
+	IDToken string
+	// The sid claim of the ID token, identifying the login at the provider
+	// in back-channel logouts. Empty if the provider does not set it.
+	SessionID string
+}
+
 type Service interface {
 	AuthURL() (url string, state any)
-	Exchange(ctx context.Context, code, state string, verifier any) (*User, error)
+	Exchange(ctx context.Context, code, state string, verifier any) (*User, *Tokens, error)
+	// Refresh re-validates the user with a refresh token. The returned tokens
+	// replace the previous ones, except for an empty ID token if the provider
+	// did not issue a new one. Returns ErrInvalidGrant if the refresh token
+	// was rejected, eg. because the user was deactivated.
+	Refresh(ctx context.Context, refreshToken string) (*User, *Tokens, error)
+
+	// LogoutURL returns the URL of the provider's end_session_endpoint, to
+	// log out of the provider as well, or false if it does not support
+	// RP-initiated logout.
+	LogoutURL(idTokenHint string) (string, bool)
+	// VerifyLogoutToken validates a back-channel logout token.
+	VerifyLogoutToken(ctx context.Context, rawToken string) (*LogoutToken, error)
 }
 
+// ErrInvalidGrant is returned by Refresh if the login is no longer valid.
+var ErrInvalidGrant = errors.New("invalid grant")
+
 type service struct {
 	clientID     string
 	clientSecret string
 	scopes       []string
 
+	// guards config, whose secret may be rotated
+	mu       sync.RWMutex
 	config   *oauth2.Config
 	provider *oidc.Provider
+
+	endSessionEndpoint    string
+	postLogoutRedirectURL string
 }
 
 var _ Service = (*service)(nil)
@@ -84,6 +170,14 @@ func New(ctx context.Context, cfg Config) (*service, error) {
 		return nil, err
 	}
 
+	// optional, for RP-initiated logout
+	var providerClaims struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&providerClaims); err != nil {
+		return nil, err
+	}
+
 	oauthCfg := &oauth2.Config{
 		ClientID:     cfg.ClientID,
 		ClientSecret: cfg.ClientSecret,
@@ -98,9 +192,30 @@ func New(ctx context.Context, cfg Config) (*service, error) {
 		scopes:       cfg.Scopes,
 		provider:     provider,
 		config:       oauthCfg,
+
+		endSessionEndpoint:    providerClaims.EndSessionEndpoint,
+		postLogoutRedirectURL: cfg.PostLogoutRedirectURL,
 	}, nil
 }
 
+// SetClientSecret rotates the client secret used for new logins.
+func (svc *service) SetClientSecret(clientSecret string) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	cfg := *svc.config
+	cfg.ClientSecret = clientSecret
+	svc.config = &cfg
+	svc.clientSecret = clientSecret
+}
+
+// oauthConfig returns the current OAuth2 configuration.
+func (svc *service) oauthConfig() *oauth2.Config {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	return svc.config
+}
+
 type oidcVerififer struct {
 	// oidcVerififer must be serializable, thus we export all fields
 	State    string
@@ -112,7 +227,7 @@ func (svc *service) AuthURL() (string, any) {
 		State:    oauth2.GenerateVerifier(),
 		Verifier: oauth2.GenerateVerifier(),
 	}
-	authURL := svc.config.
+	authURL := svc.oauthConfig().
 		AuthCodeURL(
 			ver.State,
 			oauth2.AccessTypeOffline,
@@ -121,51 +236,99 @@ func (svc *service) AuthURL() (string, any) {
 	return authURL, &ver
 }
 
-func (svc *service) Exchange(ctx context.Context, code, state string, verifier any) (*User, error) {
+func (svc *service) Exchange(ctx context.Context, code, state string, verifier any) (*User, *Tokens, error) {
 	ver, ok := verifier.(*oidcVerififer)
 	if !ok {
-		return nil, errors.New("invalid verifier")
+		return nil, nil, errors.New("invalid verifier")
 	}
 	if state != ver.State {
-		return nil, errors.New("state mismatch")
+		return nil, nil, errors.New("state mismatch")
 	}
 
-	token, err := svc.config.
+	token, err := svc.oauthConfig().
 		Exchange(ctx, code, oauth2.VerifierOption(ver.Verifier))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if _, ok := token.Extra("id_token").(string); !ok {
+		return nil, nil, errors.New("missing id_token")
 	}
 
-	rawIDToken, _ := token.Extra("id_token").(string)
-	idToken, err := svc.provider.
-		VerifierContext(ctx, &oidc.Config{ClientID: svc.clientID}).
-		Verify(ctx, rawIDToken)
+	user, tokens, err := svc.user(ctx, token)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return user, tokens, nil
+}
 
+func (svc *service) Refresh(ctx context.Context, refreshToken string) (*User, *Tokens, error) {
+	// an expired token forces a refresh
+	token, err := svc.oauthConfig().
+		TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken, Expiry: time.Unix(1, 0)}).
+		Token()
+	if err != nil {
+		var rerr *oauth2.RetrieveError
+		if errors.As(err, &rerr) && rerr.ErrorCode == "invalid_grant" {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+		}
+		return nil, nil, err
+	}
+
+	user, tokens, err := svc.user(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tokens.RefreshToken == "" {
+		// not rotated
+		tokens.RefreshToken = refreshToken
+	}
+	return user, tokens, nil
+}
+
+// user returns the user authenticated by token. The ID token is empty if the
+// token has none.
+func (svc *service) user(ctx context.Context, token *oauth2.Token) (*User, *Tokens, error) {
 	var user User
-	if err := idToken.Claims(&user); err != nil {
-		return nil, err
+	tokens := &Tokens{RefreshToken: token.RefreshToken}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken != "" {
+		idToken, err := svc.provider.
+			VerifierContext(ctx, &oidc.Config{ClientID: svc.clientID}).
+			Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		var sidClaim struct {
+			SessionID string `json:"sid"`
+		}
+		if err := idToken.Claims(&user); err != nil {
+			return nil, nil, err
+		}
+		if err := idToken.Claims(&sidClaim); err != nil {
+			return nil, nil, err
+		}
+		tokens.IDToken, tokens.SessionID = rawIDToken, sidClaim.SessionID
 	}
 
 	// Fetch additional claims from userinfo endpoint (email is not in ID token)
 	userInfo, err := svc.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
 	if err != nil {
-		return nil, err
-	}
-	var extraClaims struct {
-		Email string `json:"email"`
+		return nil, nil, err
 	}
+	var extraClaims User
 	if err := userInfo.Claims(&extraClaims); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if rawIDToken == "" {
+		// refresh responses may omit the ID token
+		user = extraClaims
 	}
 	user.Email = extraClaims.Email
 
 	// sanity check, ensure users are valid
 	if user.Username == "" {
-		return nil, errors.New("invalid user")
+		return nil, nil, errors.New("invalid user")
 	}
 
-	return &user, nil
+	return &user, tokens, nil
 }
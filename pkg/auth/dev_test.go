@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDevService(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc := NewDev()
+
+	authURL, verifier := svc.AuthURL()
+	u, err := url.Parse(authURL)
+	if err != nil || u.Path != DevLoginPath {
+		t.Fatalf("AuthURL() = %q; want %s", authURL, DevLoginPath)
+	}
+	state := u.Query().Get("state")
+
+	user := &User{Username: "alice", Email: "alice@example.com", Groups: []string{"undergraduate", "bacchus"}}
+	code := svc.Code(user)
+
+	if _, _, err := svc.Exchange(ctx, code, "other", verifier); err == nil {
+		t.Errorf("Exchange() with mismatched state = nil; want error")
+	}
+
+	got, tokens, err := svc.Exchange(ctx, code, state, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() = %v", err)
+	}
+	want := &User{Subject: "alice", Username: "alice", Email: "alice@example.com", Groups: []string{"undergraduate", "bacchus"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Exchange() mismatch\n%s", diff)
+	}
+
+	got, _, err = svc.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() = %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Refresh() mismatch\n%s", diff)
+	}
+
+	if _, _, err := svc.Refresh(ctx, strings.Repeat("x", 7)); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Refresh(invalid) = %v; want %v", err, ErrInvalidGrant)
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// backchannelLogoutEvent is required in the events claim of logout tokens.
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// Logout tokens older than this are rejected.
+const maxLogoutTokenAge = 5 * time.Minute
+
+// LogoutToken identifies the logins to end in a back-channel logout: those
+// of Subject, or only the one of SessionID if set.
+type LogoutToken struct {
+	Subject   string
+	SessionID string
+}
+
+func (svc *service) LogoutURL(idTokenHint string) (string, bool) {
+	if svc.endSessionEndpoint == "" {
+		return "", false
+	}
+	u, err := url.Parse(svc.endSessionEndpoint)
+	if err != nil {
+		return "", false
+	}
+
+	q := u.Query()
+	q.Set("client_id", svc.clientID)
+	if idTokenHint != "" {
+		q.Set("id_token_hint", idTokenHint)
+	}
+	if svc.postLogoutRedirectURL != "" {
+		q.Set("post_logout_redirect_uri", svc.postLogoutRedirectURL)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), true
+}
+
+// VerifyLogoutToken validates rawToken as specified by OpenID Connect
+// Back-Channel Logout 1.0, section 2.6.
+func (svc *service) VerifyLogoutToken(ctx context.Context, rawToken string) (*LogoutToken, error) {
+	token, err := svc.provider.
+		// logout tokens may not expire, checked below instead
+		VerifierContext(ctx, &oidc.Config{ClientID: svc.clientID, SkipExpiryCheck: true}).
+		Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !token.Expiry.IsZero() && now.After(token.Expiry) {
+		return nil, errors.New("logout token expired")
+	}
+	if now.Sub(token.IssuedAt) > maxLogoutTokenAge {
+		return nil, errors.New("logout token too old")
+	}
+
+	var claims struct {
+		SessionID string         `json:"sid"`
+		Events    map[string]any `json:"events"`
+		Nonce     *string        `json:"nonce"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok {
+		return nil, errors.New("logout token without logout event")
+	}
+	if claims.Nonce != nil {
+		// prevents ID tokens from being used as logout tokens
+		return nil, errors.New("logout token with nonce")
+	}
+	if token.Subject == "" && claims.SessionID == "" {
+		return nil, errors.New("logout token without sub or sid")
+	}
+
+	return &LogoutToken{Subject: token.Subject, SessionID: claims.SessionID}, nil
+}
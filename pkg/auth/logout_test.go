@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+func TestLogoutURL(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := (&service{}).LogoutURL("id-token"); ok {
+		t.Errorf("LogoutURL() without end_session_endpoint = _, true; want false")
+	}
+
+	svc := &service{
+		clientID:              "sgs",
+		endSessionEndpoint:    "https://id.example.com/logout?ui=1",
+		postLogoutRedirectURL: "https://sgs.example.com/auth/logout",
+	}
+	got, ok := svc.LogoutURL("id-token")
+	want := "https://id.example.com/logout?client_id=sgs&id_token_hint=id-token&post_logout_redirect_uri=https%3A%2F%2Fsgs.example.com%2Fauth%2Flogout&ui=1"
+	if !ok || got != want {
+		t.Errorf("LogoutURL() = %q, %v; want %q, true", got, ok, want)
+	}
+}
+
+// fakeProvider serves the signing key of the tokens it issues.
+type fakeProvider struct {
+	key    *rsa.PrivateKey
+	server *httptest.Server
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return &fakeProvider{key: key, server: server}
+}
+
+func (p *fakeProvider) service() *service {
+	provider := (&oidc.ProviderConfig{
+		IssuerURL: p.server.URL,
+		JWKSURL:   p.server.URL + "/jwks",
+	}).NewProvider(context.Background())
+	return &service{clientID: "sgs", provider: provider}
+}
+
+// sign returns a token with claims, signed by p.
+func (p *fakeProvider) sign(t *testing.T, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "logout+jwt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyLogoutToken(t *testing.T) {
+	t.Parallel()
+
+	p := newFakeProvider(t)
+	svc := p.service()
+
+	// claims of a valid logout token, changed by each test
+	claims := func(change func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":    p.server.URL,
+			"aud":    "sgs",
+			"iat":    time.Now().Unix(),
+			"jti":    "logout-1",
+			"sub":    "user-sub",
+			"sid":    "user-sid",
+			"events": map[string]any{backchannelLogoutEvent: map[string]any{}},
+		}
+		change(c)
+		return c
+	}
+
+	tests := map[string]struct {
+		claims  map[string]any
+		want    *LogoutToken
+		wantErr string
+	}{
+		"valid": {
+			claims: claims(func(c map[string]any) {}),
+			want:   &LogoutToken{Subject: "user-sub", SessionID: "user-sid"},
+		},
+		"sid-only": {
+			claims: claims(func(c map[string]any) { delete(c, "sub") }),
+			want:   &LogoutToken{SessionID: "user-sid"},
+		},
+		"no-events": {
+			claims:  claims(func(c map[string]any) { delete(c, "events") }),
+			wantErr: "without logout event",
+		},
+		"other-event": {
+			claims:  claims(func(c map[string]any) { c["events"] = map[string]any{"urn:other": map[string]any{}} }),
+			wantErr: "without logout event",
+		},
+		"nonce": {
+			claims:  claims(func(c map[string]any) { c["nonce"] = "n" }),
+			wantErr: "with nonce",
+		},
+		"no-sub-or-sid": {
+			claims: claims(func(c map[string]any) {
+				delete(c, "sub")
+				delete(c, "sid")
+			}),
+			wantErr: "without sub or sid",
+		},
+		"too-old": {
+			claims:  claims(func(c map[string]any) { c["iat"] = time.Now().Add(-time.Hour).Unix() }),
+			wantErr: "too old",
+		},
+		"expired": {
+			claims:  claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+			wantErr: "expired",
+		},
+		"wrong-audience": {
+			claims:  claims(func(c map[string]any) { c["aud"] = "other" }),
+			wantErr: "audience",
+		},
+		"wrong-issuer": {
+			claims:  claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" }),
+			wantErr: "different provider",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := svc.VerifyLogoutToken(context.Background(), p.sign(t, tt.claims))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("VerifyLogoutToken() = %+v, %v; want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyLogoutToken() = %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("VerifyLogoutToken() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// signed by someone else
+	other := newFakeProvider(t)
+	if _, err := svc.VerifyLogoutToken(context.Background(), other.sign(t, claims(func(c map[string]any) {}))); err == nil {
+		t.Error("VerifyLogoutToken() of a foreign token = nil; want error")
+	}
+}
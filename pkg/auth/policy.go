@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// Action is something a user may be allowed to do, see Policy.Can.
+type Action string
+
+const (
+	// View the workspace, or with a nil workspace, list other users'
+	// workspaces.
+	ActionView Action = "view"
+	// Request a workspace, or changes to one, in the workspace's nodegroup.
+	ActionRequest Action = "request"
+	// Approve or reject the pending request of the workspace, and preview it.
+	ActionReview Action = "review"
+	// Change the workspace beyond its request: quotas, users, and cluster.
+	ActionManage Action = "manage"
+	// Delete the workspace.
+	ActionDelete Action = "delete"
+	// Subscribe to emails of requests and provisioning failures.
+	ActionSubscribe Action = "subscribe"
+	// List and revoke other users' sessions. The workspace is ignored.
+	ActionSessions Action = "sessions"
+	// View the site as another user, read-only. The workspace is ignored.
+	ActionImpersonate Action = "impersonate"
+	// View the outbox of notifications, and retry failed ones. The workspace
+	// is ignored.
+	ActionOutbox Action = "outbox"
+	// View the metrics at /debug/vars, which include the command line and
+	// the worker's state. The workspace is ignored.
+	ActionMetrics Action = "metrics"
+)
+
+// Policy decides what users may do, based on their groups:
+//
+//   - Users may request workspaces in nodegroups they belong to, and view
+//     workspaces they have accepted.
+//   - Reviewers may view all workspaces, and approve or reject requests.
+//   - Nodegroup admins may view, review, and manage workspaces in their
+//     nodegroups.
+//   - Superadmins may do anything, including deleting workspaces, revoking
+//     other users' sessions, impersonating them, retrying notifications, and
+//     viewing metrics.
+type Policy struct {
+	superadmins []string
+	reviewers   []string
+	// group -> nodegroups
+	nodegroupAdmins map[string][]model.Nodegroup
+}
+
+func NewPolicy(cfg Config) *Policy {
+	p := &Policy{
+		superadmins:     cfg.SuperadminGroups,
+		reviewers:       cfg.ReviewerGroups,
+		nodegroupAdmins: make(map[string][]model.Nodegroup, len(cfg.NodegroupAdmins)),
+	}
+	for _, na := range cfg.NodegroupAdmins {
+		for _, ng := range na.Nodegroups {
+			p.nodegroupAdmins[na.Group] = append(p.nodegroupAdmins[na.Group], model.Nodegroup(ng))
+		}
+	}
+	return p
+}
+
+// Can reports whether user may perform act on ws. A nil ws asks whether user
+// may perform act on any workspace other than their own.
+func (p *Policy) Can(user *User, act Action, ws *model.Workspace) bool {
+	if user == nil {
+		return false
+	}
+	if act == ActionRequest {
+		// everyone, admins included, requests for their own nodegroups
+		return ws != nil && slices.Contains(user.Groups, string(ws.Nodegroup))
+	}
+	if inAny(user, p.superadmins) {
+		return true
+	}
+
+	reviewer := inAny(user, p.reviewers)
+	var ngAdmin bool
+	for _, g := range user.Groups {
+		ngs := p.nodegroupAdmins[g]
+		if ws == nil && len(ngs) > 0 || ws != nil && slices.Contains(ngs, ws.Nodegroup) {
+			ngAdmin = true
+			break
+		}
+	}
+	member := ws != nil && slices.ContainsFunc(ws.Users, func(u model.WorkspaceUser) bool {
+		return u.Username == user.Username && u.IsAccepted()
+	})
+
+	switch act {
+	case ActionView:
+		return member || reviewer || ngAdmin
+	case ActionReview, ActionSubscribe:
+		return reviewer || ngAdmin
+	case ActionManage:
+		return ngAdmin
+	default:
+		// ActionDelete, ActionSessions, ActionImpersonate, ActionOutbox, and
+		// ActionMetrics, superadmins only
+		return false
+	}
+}
+
+func inAny(user *User, groups []string) bool {
+	return slices.ContainsFunc(user.Groups, func(g string) bool {
+		return slices.Contains(groups, g)
+	})
+}
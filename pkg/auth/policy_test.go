@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+func TestPolicyCan(t *testing.T) {
+	t.Parallel()
+
+	p := NewPolicy(Config{
+		SuperadminGroups: []string{"bacchus"},
+		ReviewerGroups:   []string{"reviewers"},
+		NodegroupAdmins: []NodegroupAdmin{
+			{Group: "ta", Nodegroups: []string{string(model.NodegroupUndergraduate)}},
+		},
+	})
+
+	ws := &model.Workspace{
+		Nodegroup: model.NodegroupUndergraduate,
+		Users: []model.WorkspaceUser{
+			{Username: "alice", Email: "alice@example.com"},
+			{Username: "bob"}, // pending invitation
+		},
+	}
+	grad := &model.Workspace{Nodegroup: model.NodegroupGraduate}
+
+	var (
+		alice    = &User{Username: "alice", Groups: []string{"undergraduate"}}
+		bob      = &User{Username: "bob", Groups: []string{"undergraduate"}}
+		reviewer = &User{Username: "rev", Groups: []string{"reviewers"}}
+		ta       = &User{Username: "ta", Groups: []string{"ta"}}
+		admin    = &User{Username: "admin", Groups: []string{"bacchus"}}
+	)
+
+	tests := []struct {
+		name string
+		user *User
+		act  Action
+		ws   *model.Workspace
+		want bool
+	}{
+		{"anonymous", nil, ActionView, ws, false},
+		{"member views", alice, ActionView, ws, true},
+		{"invitee can't view", bob, ActionView, ws, false},
+		{"member can't list", alice, ActionView, nil, false},
+		{"member can't review", alice, ActionReview, ws, false},
+		{"user requests in own nodegroup", alice, ActionRequest, ws, true},
+		{"user can't request in other nodegroup", alice, ActionRequest, grad, false},
+
+		{"reviewer lists", reviewer, ActionView, nil, true},
+		{"reviewer views", reviewer, ActionView, grad, true},
+		{"reviewer reviews", reviewer, ActionReview, ws, true},
+		{"reviewer can't manage", reviewer, ActionManage, ws, false},
+		{"reviewer can't delete", reviewer, ActionDelete, ws, false},
+		{"reviewer subscribes", reviewer, ActionSubscribe, nil, true},
+
+		{"nodegroup admin manages", ta, ActionManage, ws, true},
+		{"nodegroup admin reviews", ta, ActionReview, ws, true},
+		{"nodegroup admin can't view others", ta, ActionView, grad, false},
+		{"nodegroup admin can't manage others", ta, ActionManage, grad, false},
+		{"nodegroup admin can't delete", ta, ActionDelete, ws, false},
+
+		{"nodegroup admin can't revoke sessions", ta, ActionSessions, nil, false},
+		{"reviewer can't impersonate", reviewer, ActionImpersonate, nil, false},
+		{"nodegroup admin can't retry notifications", ta, ActionOutbox, nil, false},
+		{"reviewer can't view metrics", reviewer, ActionMetrics, nil, false},
+
+		{"superadmin deletes", admin, ActionDelete, ws, true},
+		{"superadmin revokes sessions", admin, ActionSessions, nil, true},
+		{"superadmin impersonates", admin, ActionImpersonate, nil, true},
+		{"superadmin retries notifications", admin, ActionOutbox, nil, true},
+		{"superadmin views metrics", admin, ActionMetrics, nil, true},
+		{"superadmin manages", admin, ActionManage, grad, true},
+		{"superadmin requests only in own nodegroups", admin, ActionRequest, grad, false},
+	}
+	for _, tt := range tests {
+		if got := p.Can(tt.user, tt.act, tt.ws); got != tt.want {
+			t.Errorf("%s: Can(%s) = %v; want %v", tt.name, tt.act, got, tt.want)
+		}
+	}
+}
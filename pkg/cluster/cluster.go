@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/spf13/viper"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/secret"
+)
+
+// DefaultName is the name of the implicit cluster, used if none are
+// configured.
+const DefaultName = "default"
+
+// Cluster is a Kubernetes cluster workspaces are provisioned in.
+type Cluster struct {
+	Name   string `mapstructure:"name"`
+	Server string `mapstructure:"server"`
+	// Base64-encoded PEM certificate of the API server CA, as in kubeconfig
+	// certificate-authority-data.
+	CAData string `mapstructure:"ca_data"`
+
+	// Workspaces of these nodegroups are placed in this cluster, unless
+	// assigned otherwise.
+	Nodegroups []string `mapstructure:"nodegroups"`
+	// Worker command of this cluster, instead of the worker stages.
+	Command string `mapstructure:"command"`
+	// Command honours SGS_WORKER_DRY_RUN, and may be run to preview changes.
+	DryRun bool `mapstructure:"dry_run"`
+}
+
+// OIDC settings of the Kubernetes API servers, used by kubectl oidc-login in
+// the kubeconfigs handed out to users.
+type OIDC struct {
+	Issuer   string `mapstructure:"issuer"`
+	ClientID string `mapstructure:"client_id"`
+	// Written into every kubeconfig, so only set for clients whose secret is
+	// not confidential. Usually empty, for a public client.
+	ClientSecret string `mapstructure:"client_secret"`
+	// ClientSecretFile is read instead of ClientSecret, if set. It is read
+	// once, so rotating it requires a restart, not SIGHUP.
+	ClientSecretFile string   `mapstructure:"client_secret_file"`
+	ExtraScopes      []string `mapstructure:"extra_scopes"`
+}
+
+type Config struct {
+	Clusters []Cluster `mapstructure:"clusters"`
+	OIDC     OIDC      `mapstructure:"oidc"`
+}
+
+func (c *Config) Bind() {
+	// JSON-encoded list of clusters
+	viper.BindEnv("cluster.clusters", "SGS_CLUSTERS")
+
+	// the issuer defaults to that of sgs, the client must be set explicitly
+	viper.BindEnv("cluster.oidc.issuer", "SGS_KUBE_OIDC_ISSUER")
+	viper.BindEnv("cluster.oidc.client_id", "SGS_KUBE_OIDC_CLIENT_ID")
+	viper.BindEnv("cluster.oidc.client_secret", "SGS_KUBE_OIDC_CLIENT_SECRET")
+	viper.BindEnv("cluster.oidc.client_secret_file", "SGS_KUBE_OIDC_CLIENT_SECRET_FILE")
+	viper.BindEnv("cluster.oidc.extra_scopes", "SGS_KUBE_OIDC_EXTRA_SCOPES")
+
+	viper.SetDefault("cluster.oidc.extra_scopes", []string{"email", "groups"})
+}
+
+func (c *Config) Validate() error {
+	if err := secret.Load(&c.OIDC.ClientSecret, c.OIDC.ClientSecretFile); err != nil {
+		return fmt.Errorf("oidc.client_secret_file: %w", err)
+	}
+
+	if len(c.Clusters) == 0 {
+		// a single cluster, configured by the worker alone
+		ngs := make([]string, len(model.Nodegroups))
+		for i, ng := range model.Nodegroups {
+			ngs[i] = string(ng)
+		}
+		c.Clusters = []Cluster{{Name: DefaultName, Nodegroups: ngs}}
+		return nil
+	}
+
+	var err error
+	// kubeconfigs are only handed out for configured clusters
+	if c.OIDC.ClientID == "" {
+		err = errors.Join(err, errors.New("oidc.client_id is required with clusters"))
+	}
+	names := make(map[string]bool, len(c.Clusters))
+	placed := make(map[string]string, len(model.Nodegroups))
+	for i, cl := range c.Clusters {
+		if cl.Name == "" {
+			err = errors.Join(err, fmt.Errorf("clusters[%d]: name is required", i))
+		} else if names[cl.Name] {
+			err = errors.Join(err, fmt.Errorf("clusters[%d]: duplicate name %q", i, cl.Name))
+		}
+		names[cl.Name] = true
+
+		if u, uerr := url.Parse(cl.Server); uerr != nil || u.Scheme != "https" || u.Host == "" {
+			err = errors.Join(err, fmt.Errorf("clusters[%d]: invalid server %q", i, cl.Server))
+		}
+		if _, derr := base64.StdEncoding.DecodeString(cl.CAData); derr != nil {
+			err = errors.Join(err, fmt.Errorf("clusters[%d]: invalid ca_data: %w", i, derr))
+		}
+
+		for _, ng := range cl.Nodegroups {
+			if !model.Nodegroup(ng).Valid() {
+				err = errors.Join(err, fmt.Errorf("clusters[%d]: unknown nodegroup %q", i, ng))
+			} else if other, ok := placed[ng]; ok {
+				err = errors.Join(err, fmt.Errorf("clusters[%d]: nodegroup %q is already placed in %q", i, ng, other))
+			}
+			placed[ng] = cl.Name
+		}
+	}
+	for _, ng := range model.Nodegroups {
+		if _, ok := placed[string(ng)]; !ok {
+			err = errors.Join(err, fmt.Errorf("nodegroup %q is not placed in any cluster", ng))
+		}
+	}
+	return err
+}
+
+// Redacted returns a copy of c with secrets redacted.
+func (c Config) Redacted() Config {
+	if c.OIDC.ClientSecret != "" {
+		c.OIDC.ClientSecret = "REDACTED"
+	}
+	return c
+}
+
+// Names of all clusters, in configuration order.
+func (c *Config) Names() []string {
+	names := make([]string, len(c.Clusters))
+	for i, cl := range c.Clusters {
+		names[i] = cl.Name
+	}
+	return names
+}
+
+// Get the cluster with the given name.
+func (c *Config) Get(name string) (Cluster, bool) {
+	i := slices.IndexFunc(c.Clusters, func(cl Cluster) bool { return cl.Name == name })
+	if i < 0 {
+		return Cluster{}, false
+	}
+	return c.Clusters[i], true
+}
+
+// Default returns the cluster workspaces of the nodegroup are placed in.
+func (c *Config) Default(ng model.Nodegroup) string {
+	for _, cl := range c.Clusters {
+		if slices.Contains(cl.Nodegroups, string(ng)) {
+			return cl.Name
+		}
+	}
+	return ""
+}
+
+// Place returns the cluster of ws: the one assigned by administrators, or the
+// default of its nodegroup.
+func (c *Config) Place(ws *model.Workspace) string {
+	if ws.Cluster != "" {
+		return ws.Cluster
+	}
+	return c.Default(ws.Nodegroup)
+}
@@ -1,15 +1,21 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 
 	"github.com/bacchus-snu/sgs/controller"
 	"github.com/bacchus-snu/sgs/model/postgres"
 	"github.com/bacchus-snu/sgs/pkg/auth"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
 	"github.com/bacchus-snu/sgs/pkg/email"
+	"github.com/bacchus-snu/sgs/pkg/notify"
 	"github.com/bacchus-snu/sgs/worker"
 )
 
@@ -18,35 +24,82 @@ type Validator interface {
 	Validate() error
 }
 
+// SearchPaths are searched for a config file named sgs.yaml (or .toml,
+// .json), if none is given explicitly.
+var SearchPaths = []string{".", "/etc/sgs"}
+
 type Config struct {
 	Auth       auth.Config       `mapstructure:"auth"`
 	Controller controller.Config `mapstructure:"controller"`
 	Postgres   postgres.Config   `mapstructure:"postgres"`
 	Worker     worker.Config     `mapstructure:"worker"`
+	Cluster    cluster.Config    `mapstructure:"cluster"`
 	Email      email.Config      `mapstructure:"email"`
+	Notify     notify.Config     `mapstructure:"notify"`
 }
 
 var _ Validator = (*Config)(nil)
 
-func Load() (*Config, error) {
-	cfg := Config{}
-	cfg.Bind()
-
-	if err := viper.Unmarshal(&cfg); err != nil {
+// Load reads the configuration from file, or the first config file found in
+// SearchPaths if empty, and the environment, and validates it. Environment
+// variables override the config file.
+func Load(file string) (*Config, error) {
+	cfg, err := Read(file)
+	if err != nil {
 		return nil, err
 	}
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	return cfg, nil
+}
+
+// Read is like Load, but does not validate the configuration.
+func Read(file string) (*Config, error) {
+	if file != "" {
+		viper.SetConfigFile(file)
+	} else {
+		viper.SetConfigName("sgs")
+		for _, p := range SearchPaths {
+			viper.AddConfigPath(p)
+		}
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		// the config file is optional, unless given explicitly
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) || file != "" {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+	}
+
+	cfg := Config{}
+	cfg.Bind()
+
+	hooks := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		stringToJSONHookFunc(),
+		// viper defaults
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := viper.Unmarshal(&cfg, hooks); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// File returns the config file in use, if any.
+func File() string {
+	return viper.ConfigFileUsed()
+}
+
 func (c *Config) Bind() {
 	c.Auth.Bind()
 	c.Controller.Bind()
 	c.Postgres.Bind()
 	c.Worker.Bind()
+	c.Cluster.Bind()
 	c.Email.Bind()
+	c.Notify.Bind()
 }
 
 func (c *Config) Validate() error {
@@ -64,9 +117,48 @@ func (c *Config) Validate() error {
 	if err1 := c.Worker.Validate(); err1 != nil {
 		err = errors.Join(err, fmt.Errorf("worker: %w", err1))
 	}
+	if err1 := c.Cluster.Validate(); err1 != nil {
+		err = errors.Join(err, fmt.Errorf("cluster: %w", err1))
+	}
+	// the API servers usually trust the same issuer as sgs, but never the
+	// client of sgs, whose secret must not be handed out in kubeconfigs
+	if c.Cluster.OIDC.Issuer == "" {
+		c.Cluster.OIDC.Issuer = c.Auth.Issuer
+	}
 	if err1 := c.Email.Validate(); err1 != nil {
 		err = errors.Join(err, fmt.Errorf("email: %w", err1))
 	}
+	if err1 := c.Notify.Validate(); err1 != nil {
+		err = errors.Join(err, fmt.Errorf("notify: %w", err1))
+	}
 
 	return err
 }
+
+// stringToJSONHookFunc decodes JSON-encoded environment variables into lists
+// and maps of structs, eg. SGS_WORKER_STAGES.
+func stringToJSONHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from, to reflect.Type, data any) (any, error) {
+		if from.Kind() != reflect.String {
+			return data, nil
+		}
+		switch to.Kind() {
+		case reflect.Slice, reflect.Map:
+			if to.Elem().Kind() != reflect.Struct {
+				return data, nil
+			}
+		default:
+			return data, nil
+		}
+
+		s := strings.TrimSpace(data.(string))
+		if s == "" {
+			return nil, nil
+		}
+		var out any
+		if err := json.Unmarshal([]byte(s), &out); err != nil {
+			return nil, fmt.Errorf("decoding JSON: %w", err)
+		}
+		return out, nil
+	}
+}
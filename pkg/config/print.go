@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted returns a copy of the configuration with secrets redacted.
+func (c Config) Redacted() Config {
+	c.Auth = c.Auth.Redacted()
+	c.Controller = c.Controller.Redacted()
+	c.Postgres = c.Postgres.Redacted()
+	c.Worker = c.Worker.Redacted()
+	c.Cluster = c.Cluster.Redacted()
+	c.Email = c.Email.Redacted()
+	c.Notify = c.Notify.Redacted()
+	return c
+}
+
+// Print writes the configuration as YAML, keyed like the config file, with
+// secrets redacted.
+func Print(w io.Writer, c *Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(settings(reflect.ValueOf(c.Redacted()))); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// settings converts v to the plain values of a config file, following the
+// mapstructure tags.
+func settings(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return settings(v.Elem())
+	case reflect.Struct:
+		out := map[string]any{}
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			out[name] = settings(v.Field(i))
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]any, v.Len())
+		for i := range v.Len() {
+			out[i] = settings(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]any, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			out[fmt.Sprint(it.Key().Interface())] = settings(it.Value())
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
@@ -2,8 +2,11 @@ package email
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/spf13/viper"
+
+	"github.com/bacchus-snu/sgs/pkg/secret"
 )
 
 type Config struct {
@@ -11,7 +14,11 @@ type Config struct {
 	Port     int    `mapstructure:"port"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
-	From     string `mapstructure:"from"`
+	// PasswordFile is read instead of Password, if set.
+	PasswordFile string `mapstructure:"password_file"`
+	From         string `mapstructure:"from"`
+	// Templates in this directory override the embedded ones, see templates.
+	TemplateDir string `mapstructure:"template_dir"`
 }
 
 func (c *Config) Bind() {
@@ -19,7 +26,9 @@ func (c *Config) Bind() {
 	viper.BindEnv("email.port", "SGS_EMAIL_PORT")
 	viper.BindEnv("email.username", "SGS_EMAIL_USERNAME")
 	viper.BindEnv("email.password", "SGS_EMAIL_PASSWORD")
+	viper.BindEnv("email.password_file", "SGS_EMAIL_PASSWORD_FILE")
 	viper.BindEnv("email.from", "SGS_EMAIL_FROM")
+	viper.BindEnv("email.template_dir", "SGS_EMAIL_TEMPLATE_DIR")
 
 	// Defaults
 	viper.SetDefault("email.host", "smtp.gmail.com")
@@ -28,6 +37,9 @@ func (c *Config) Bind() {
 
 func (c *Config) Validate() error {
 	var errs []error
+	if err := secret.Load(&c.Password, c.PasswordFile); err != nil {
+		errs = append(errs, fmt.Errorf("email.password_file: %w", err))
+	}
 	if c.Host == "" {
 		errs = append(errs, errors.New("email.host is required"))
 	}
@@ -43,5 +55,16 @@ func (c *Config) Validate() error {
 	if c.From == "" {
 		errs = append(errs, errors.New("email.from is required"))
 	}
+	if err := (templates{dir: c.TemplateDir}).check(); err != nil {
+		errs = append(errs, fmt.Errorf("email.template_dir: %w", err))
+	}
 	return errors.Join(errs...)
 }
+
+// Redacted returns a copy of c with secrets redacted.
+func (c Config) Redacted() Config {
+	if c.Password != "" {
+		c.Password = "REDACTED"
+	}
+	return c
+}
@@ -0,0 +1,104 @@
+package email
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// digestData is passed to the digest template.
+type digestData struct {
+	// all pending requests, and by nodegroup, oldest first
+	Pending    []digestWorkspace
+	Nodegroups []digestNodegroup
+	// workspaces failing to provision
+	Failed []digestWorkspace
+}
+
+type digestNodegroup struct {
+	Nodegroup model.Nodegroup
+	Requests  []digestWorkspace
+}
+
+type digestWorkspace struct {
+	Workspace *model.Workspace
+	// who filed the pending request
+	Requester   string
+	PendingDays int
+	URL         string
+}
+
+// requestEvent returns the event of the pending request of ws, which is new
+// unless ws was enabled before.
+func requestEvent(ws *model.Workspace) model.NotificationEvent {
+	if ws.Created {
+		return model.EventWorkspaceUpdateRequested
+	}
+	return model.EventWorkspaceRequested
+}
+
+// newDigestData summarises the pending requests and provisioning failures
+// of wss at now, that sub is subscribed to.
+func newDigestData(wss []*model.Workspace, now time.Time, links Links, sub model.Subscriber) digestData {
+	var d digestData
+	for _, ws := range wss {
+		dw := digestWorkspace{Workspace: ws, URL: links.WorkspaceURL(ws.ID)}
+		if ws.Provision.State == model.ProvisionFailed && sub.Wants(model.EventWorkspaceSyncFailed, ws.Nodegroup) {
+			d.Failed = append(d.Failed, dw)
+		}
+		if ws.Request != nil && sub.Wants(requestEvent(ws), ws.Nodegroup, ws.Request.Nodegroup) {
+			dw.Requester = ws.Request.ByUser
+			dw.PendingDays = int(now.Sub(ws.Request.RequestedAt) / (24 * time.Hour))
+			d.Pending = append(d.Pending, dw)
+		}
+	}
+
+	slices.SortStableFunc(d.Pending, func(a, b digestWorkspace) int {
+		return a.Workspace.Request.RequestedAt.Compare(b.Workspace.Request.RequestedAt)
+	})
+	for _, ng := range model.Nodegroups {
+		var reqs []digestWorkspace
+		for _, dw := range d.Pending {
+			if dw.Workspace.Request.Nodegroup == ng {
+				reqs = append(reqs, dw)
+			}
+		}
+		if len(reqs) > 0 {
+			d.Nodegroups = append(d.Nodegroups, digestNodegroup{Nodegroup: ng, Requests: reqs})
+		}
+	}
+	slices.SortFunc(d.Failed, func(a, b digestWorkspace) int {
+		return cmp.Compare(a.Workspace.ID, b.Workspace.ID)
+	})
+	return d
+}
+
+func (s *smtpService) SendDigest(ctx context.Context, wss []*model.Workspace, now time.Time) error {
+	subscribers, err := s.subscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	// every subscriber gets their own digest
+	var errs []error
+	for _, sub := range subscribers {
+		if sub.Mode != model.ModeDigest {
+			continue
+		}
+		d := newDigestData(wss, now, s.links, sub)
+		if len(d.Pending) == 0 && len(d.Failed) == 0 {
+			continue
+		}
+		rcpts := []Recipient{{Username: sub.Username, Email: sub.Email}}
+		if err := s.send(ctx, rcpts, tmplDigest, d); err != nil {
+			slog.Error("failed to send digest", "error", err, "username", sub.Username)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+func TestDigest(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	request := func(ng model.Nodegroup, days int) *model.WorkspaceUpdate {
+		return &model.WorkspaceUpdate{ByUser: "alice", Nodegroup: ng, RequestedAt: now.AddDate(0, 0, -days)}
+	}
+	wss := []*model.Workspace{
+		{ID: 1, Request: request(model.NodegroupGraduate, 1)},
+		{ID: 2, Request: request(model.NodegroupUndergraduate, 0)},
+		{ID: 3, Request: request(model.NodegroupUndergraduate, 5)},
+		{ID: 4, Provision: model.ProvisionStatus{State: model.ProvisionFailed, Message: "no harbor"}},
+		{ID: 5, Provision: model.ProvisionStatus{State: model.ProvisionReady}},
+	}
+
+	d := newDigestData(wss, now, testLinks{}, model.Subscriber{Mode: model.ModeDigest})
+	ids := func(dws []digestWorkspace) []model.ID {
+		var ids []model.ID
+		for _, dw := range dws {
+			ids = append(ids, dw.Workspace.ID)
+		}
+		return ids
+	}
+	if diff := cmp.Diff([]model.ID{3, 1, 2}, ids(d.Pending)); diff != "" {
+		t.Errorf("pending mismatch\n%s", diff)
+	}
+	if len(d.Nodegroups) != 2 ||
+		d.Nodegroups[0].Nodegroup != model.NodegroupUndergraduate ||
+		!cmp.Equal([]model.ID{3, 2}, ids(d.Nodegroups[0].Requests)) ||
+		d.Nodegroups[1].Nodegroup != model.NodegroupGraduate {
+		t.Errorf("nodegroups = %+v; want undergraduate requests 3, 2, then graduate", d.Nodegroups)
+	}
+	if d.Pending[0].PendingDays != 5 {
+		t.Errorf("pending days = %d; want 5", d.Pending[0].PendingDays)
+	}
+	if diff := cmp.Diff([]model.ID{4}, ids(d.Failed)); diff != "" {
+		t.Errorf("failed mismatch\n%s", diff)
+	}
+
+	// only what the subscriber is subscribed to
+	sub := model.Subscriber{
+		Mode:       model.ModeDigest,
+		Nodegroups: []model.Nodegroup{model.NodegroupUndergraduate},
+		Events:     []model.NotificationEvent{model.EventWorkspaceRequested},
+	}
+	wss[2].Created = true // requesting changes
+	if got := ids(newDigestData(wss, now, testLinks{}, sub).Pending); !cmp.Equal([]model.ID{2}, got) {
+		t.Errorf("pending of subscriber = %v; want new undergraduate requests", got)
+	}
+
+	for _, lang := range Languages {
+		msg, err := templates{}.render(lang, tmplDigest, d)
+		if err != nil {
+			t.Fatalf("render(%s) = %v", lang, err)
+		}
+		if !strings.Contains(msg.Subject, "3") || !strings.Contains(msg.Subject, "1") {
+			t.Errorf("render(%s) subject = %q; want counts", lang, msg.Subject)
+		}
+		for _, want := range []string{"https://sgs.example.com/ws/" + model.ID(3).Hash(), "no harbor", "graduate"} {
+			if !strings.Contains(msg.Text, want) || !strings.Contains(msg.HTML, want) {
+				t.Errorf("render(%s) = %q, %q; want %q", lang, msg.Text, msg.HTML, want)
+			}
+		}
+	}
+}
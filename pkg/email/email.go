@@ -2,140 +2,241 @@ package email
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/smtp"
-	"strings"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
 )
 
-// Service sends email notifications.
+// Service emails notifications to subscribed admins, and to users subject to
+// their preferences.
 type Service interface {
-	// SendWorkspaceRequestNotification notifies subscribed admins about a new workspace request.
-	SendWorkspaceRequestNotification(ctx context.Context, ws *model.Workspace, subscribers []model.Subscriber) error
-	// SendWorkspaceApprovalNotification notifies workspace users about approval/denial.
-	SendWorkspaceApprovalNotification(ctx context.Context, ws *model.Workspace, approved bool) error
+	// Notify emails the admins subscribed to n as it happens, and the users
+	// concerned with it.
+	Notify(ctx context.Context, n *model.Notification) error
+	// SendDigest emails the admins subscribed to a digest a summary of the
+	// pending requests and provisioning failures of wss at now, that they are
+	// subscribed to, unless there are none.
+	SendDigest(ctx context.Context, wss []*model.Workspace, now time.Time) error
+	// ConfirmAddress emails addr the url that confirms it as the address
+	// username is notified at.
+	ConfirmAddress(ctx context.Context, username, addr, url string) error
+}
+
+// Links are the absolute URLs linked from emails.
+type Links interface {
+	WorkspaceURL(id model.ID) string
+	DocsURL() string
 }
 
 type smtpService struct {
-	cfg  Config
+	cfg       Config
+	templates templates
+	mlSvc     model.MailingListService
+	users     model.UserService
+	policy    *auth.Policy
+	links     Links
+
+	// guards auth, whose password may be rotated
+	mu   sync.RWMutex
 	auth smtp.Auth
+	// smtp.SendMail, but for tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
-// NewSMTPService creates a new SMTP email service.
-func NewSMTPService(cfg Config) Service {
-	auth := smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
-	return &smtpService{cfg: cfg, auth: auth}
+var _ Service = (*smtpService)(nil)
+
+// NewSMTPService creates a new SMTP email service, emailing admins subscribed
+// to mlSvc, as far as policy still allows them. Emails are localised in the
+// preferred language of each recipient, as stored in users, and link to links.
+func NewSMTPService(cfg Config, mlSvc model.MailingListService, users model.UserService, policy *auth.Policy, links Links) *smtpService {
+	return &smtpService{
+		cfg:       cfg,
+		templates: templates{dir: cfg.TemplateDir},
+		mlSvc:     mlSvc,
+		users:     users,
+		policy:    policy,
+		links:     links,
+		auth:      smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host),
+		sendMail:  smtp.SendMail,
+	}
 }
 
-func (s *smtpService) sendEmail(to []string, subject, body string) error {
-	if len(to) == 0 {
-		return nil
+// SetPassword rotates the SMTP password used for new emails.
+func (s *smtpService) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = smtp.PlainAuth("", s.cfg.Username, password, s.cfg.Host)
+}
+
+// Recipient of an email, whose username determines the language.
+type Recipient struct {
+	Username string
+	Email    string
+}
+
+// Templates of the messages to admins and users, by event.
+var (
+	adminTemplates = map[model.NotificationEvent]string{
+		model.EventWorkspaceRequested:       tmplWorkspaceRequest,
+		model.EventWorkspaceUpdateRequested: tmplWorkspaceUpdateRequest,
+		model.EventWorkspaceSyncFailed:      tmplWorkspaceSyncFailed,
+		model.EventRequestStale:             tmplRequestReminder,
 	}
+	userTemplates = map[model.NotificationEvent]string{
+		model.EventWorkspaceRequested:       tmplRequestFiled,
+		model.EventWorkspaceUpdateRequested: tmplUpdateRequestFiled,
+		model.EventWorkspaceApproved:        tmplWorkspaceApproved,
+		model.EventWorkspaceDenied:          tmplWorkspaceDenied,
+		model.EventWorkspaceInvited:         tmplWorkspaceInvitation,
+		model.EventMemberAdded:              tmplMemberAdded,
+		model.EventMemberRemoved:            tmplMemberRemoved,
+		model.EventWorkspaceSyncFailed:      tmplSyncFailed,
+	}
+)
 
-	msg := fmt.Sprintf("From: %s\r\n"+
-		"To: %s\r\n"+
-		"Subject: %s\r\n"+
-		"MIME-Version: 1.0\r\n"+
-		"Content-Type: text/plain; charset=\"UTF-8\"\r\n"+
-		"\r\n"+
-		"%s",
-		s.cfg.From,
-		strings.Join(to, ", "),
-		subject,
-		body,
-	)
+// workspaceData is passed to the workspace templates.
+type workspaceData struct {
+	Workspace *model.Workspace
+	// who filed the request
+	Requester string
+	// the user invited, added, or removed
+	Username string
+	GPUs     uint64
+	// of the workspace page, and the documentation
+	URL     string
+	DocsURL string
+}
 
-	addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
-	return smtp.SendMail(addr, s.auth, s.cfg.From, to, []byte(msg))
+func newWorkspaceData(n *model.Notification, links Links) workspaceData {
+	ws := n.Workspace
+
+	requester := n.Username
+	if requester == "" {
+		// Find the requester (first user with email set)
+		requester = "unknown"
+		for _, u := range ws.Users {
+			if u.Email != "" {
+				requester = u.Username
+				break
+			}
+		}
+	}
+
+	return workspaceData{
+		Workspace: ws,
+		Requester: requester,
+		Username:  n.Username,
+		GPUs:      ws.Quotas[model.ResGPURequest],
+		URL:       links.WorkspaceURL(ws.ID),
+		DocsURL:   links.DocsURL(),
+	}
 }
 
-func (s *smtpService) SendWorkspaceRequestNotification(ctx context.Context, ws *model.Workspace, subscribers []model.Subscriber) error {
-	if len(subscribers) == 0 {
+// send the template name to the recipients, rendered once per language. Every
+// recipient gets their own email, so that they don't see each other's
+// addresses.
+func (s *smtpService) send(ctx context.Context, rcpts []Recipient, name string, data any) error {
+	if len(rcpts) == 0 {
 		return nil
 	}
 
-	to := make([]string, len(subscribers))
-	for i, sub := range subscribers {
-		to[i] = sub.Email
+	usernames := make([]string, len(rcpts))
+	for i, r := range rcpts {
+		usernames[i] = r.Username
+	}
+	langs, err := s.users.Languages(ctx, usernames)
+	if err != nil {
+		// still better in the wrong language than not at all
+		slog.Error("failed to look up preferred languages", "error", err)
 	}
 
-	// Find the requester (first user with email set)
-	requester := "unknown"
-	for _, u := range ws.Users {
-		if u.Email != "" {
-			requester = u.Username
-			break
+	byLang := make(map[string][]string)
+	for _, r := range rcpts {
+		lang := langs[r.Username]
+		if !slices.Contains(Languages, lang) {
+			lang = DefaultLanguage
 		}
+		byLang[lang] = append(byLang[lang], r.Email)
 	}
 
-	subject := fmt.Sprintf("[SGS] New Workspace Request from %s", requester)
-	body := fmt.Sprintf(`A new workspace has been requested:
-
-Workspace ID: %d
-Requested by: %s
-Nodegroup: %s
-GPUs: %d
-
-Review at: https://sgs.snucse.org/ws/%s
-`,
-		ws.ID,
-		requester,
-		ws.Nodegroup,
-		ws.Quotas[model.ResGPURequest],
-		ws.ID.Hash(),
-	)
-
-	if err := s.sendEmail(to, subject, body); err != nil {
-		slog.Error("failed to send workspace request notification", "error", err, "workspace_id", ws.ID)
-		return err
+	var errs []error
+	for _, lang := range Languages {
+		to := byLang[lang]
+		if len(to) == 0 {
+			continue
+		}
+		msg, err := s.templates.render(lang, name, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rendering %s/%s: %w", lang, name, err))
+			continue
+		}
+		for _, addr := range to {
+			if err := s.sendEmail(addr, msg); err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-func (s *smtpService) SendWorkspaceApprovalNotification(ctx context.Context, ws *model.Workspace, approved bool) error {
-	// Collect user emails
-	var to []string
-	for _, u := range ws.Users {
-		if u.Email != "" {
-			to = append(to, u.Email)
-		}
+func (s *smtpService) sendEmail(to string, msg *message) error {
+	b, err := msg.encode(s.cfg.From, to, time.Now())
+	if err != nil {
+		return err
 	}
 
-	if len(to) == 0 {
-		return nil
+	s.mu.RLock()
+	auth := s.auth
+	s.mu.RUnlock()
+
+	addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
+	return s.sendMail(addr, auth, s.cfg.From, []string{to}, b)
+}
+
+func (s *smtpService) Notify(ctx context.Context, n *model.Notification) error {
+	adminTmpl, toAdmins := adminTemplates[n.Event]
+	userTmpl, toUsers := userTemplates[n.Event]
+	if !toAdmins && !toUsers {
+		return fmt.Errorf("no message for %s", n.Event)
 	}
 
-	var subject, body string
-	if approved {
-		subject = "[SGS] Your Workspace Request Has Been Approved"
-		body = fmt.Sprintf(`Your workspace request has been approved and is now active.
-
-Workspace ID: %d
-Namespace: ws-%s
-
-Access your workspace: https://sgs.snucse.org/ws/%s
-Documentation: https://sgs-docs.snucse.org
-`,
-			ws.ID,
-			ws.ID.Hash(),
-			ws.ID.Hash(),
-		)
-	} else {
-		subject = "[SGS] Your Workspace Request Has Been Denied"
-		body = fmt.Sprintf(`Your workspace request has been reviewed and was not approved.
-
-Workspace ID: %d
-
-Please contact the administrators if you have questions.
-`,
-			ws.ID,
-		)
+	var errs []error
+	if toAdmins {
+		rcpts, err := s.adminRecipients(ctx, n)
+		if err != nil {
+			return err
+		}
+		if err := s.notify(ctx, n, rcpts, adminTmpl); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	if toUsers {
+		rcpts, err := s.userRecipients(ctx, n)
+		if err != nil {
+			return err
+		}
+		if err := s.notify(ctx, n, rcpts, userTmpl); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *smtpService) ConfirmAddress(ctx context.Context, username, addr, url string) error {
+	data := struct{ Username, URL string }{username, url}
+	return s.send(ctx, []Recipient{{Username: username, Email: addr}}, tmplEmailConfirmation, data)
+}
 
-	if err := s.sendEmail(to, subject, body); err != nil {
-		slog.Error("failed to send workspace approval notification", "error", err, "workspace_id", ws.ID, "approved", approved)
+func (s *smtpService) notify(ctx context.Context, n *model.Notification, rcpts []Recipient, name string) error {
+	if err := s.send(ctx, rcpts, name, newWorkspaceData(n, s.links)); err != nil {
+		slog.Error("failed to send notification", "error", err, "event", n.Event, "workspace_id", n.Workspace.ID)
 		return err
 	}
 	return nil
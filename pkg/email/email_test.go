@@ -0,0 +1,207 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+func TestMatchLanguage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		accept, want string
+	}{
+		{"", "en"},
+		{"ko-KR,ko;q=0.9,en-US;q=0.8", "ko"},
+		{"en-US,en;q=0.9,ko;q=0.8", "en"},
+		{"fr-FR", "en"},
+	}
+	for _, tt := range tests {
+		if got := MatchLanguage(tt.accept); got != tt.want {
+			t.Errorf("MatchLanguage(%q) = %q; want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	t.Parallel()
+
+	if err := (templates{}).check(); err != nil {
+		t.Fatalf("check() = %v", err)
+	}
+
+	// overrides only replace their own files
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "ko"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	override := `{{define "subject"}}승인: {{.Workspace.ID}}{{end}}본문`
+	if err := os.WriteFile(filepath.Join(dir, "ko", "workspace_approved.txt"), []byte(override), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data := newWorkspaceData(&model.Notification{Workspace: &model.Workspace{ID: 1}}, testLinks{})
+	msg, err := templates{dir: dir}.render("ko", tmplWorkspaceApproved, data)
+	if err != nil {
+		t.Fatalf("render() = %v", err)
+	}
+	if msg.Subject != "승인: 1" || msg.Text != "본문" {
+		t.Errorf("render() = %q, %q; want overridden text", msg.Subject, msg.Text)
+	}
+	if !strings.Contains(msg.HTML, "워크스페이스 요청이 승인되어") {
+		t.Errorf("render() HTML = %q; want embedded Korean template", msg.HTML)
+	}
+
+	// unknown languages fall back to the default
+	msg, err = templates{}.render("fr", tmplWorkspaceDenied, data)
+	if err != nil {
+		t.Fatalf("render(fr) = %v", err)
+	}
+	if want := "[SGS] Your Workspace Request Has Been Denied"; msg.Subject != want {
+		t.Errorf("render(fr) subject = %q; want %q", msg.Subject, want)
+	}
+
+	// links point to the configured site
+	msg, err = templates{}.render("en", tmplWorkspaceApproved, data)
+	if err != nil {
+		t.Fatalf("render(en) = %v", err)
+	}
+	for _, link := range []string{"https://sgs.example.com/ws/" + model.ID(1).Hash(), "https://docs.example.com"} {
+		if !strings.Contains(msg.Text, link) || !strings.Contains(msg.HTML, link) {
+			t.Errorf("render(en) = %q, %q; want link %q", msg.Text, msg.HTML, link)
+		}
+	}
+
+	// provisioning errors are only emailed to admins
+	failed := newWorkspaceData(&model.Notification{Workspace: &model.Workspace{
+		ID:        1,
+		Provision: model.ProvisionStatus{State: model.ProvisionFailed, Message: "apply: failed"},
+	}}, testLinks{})
+	for _, lang := range []string{"en", "ko"} {
+		for name, want := range map[string]bool{tmplSyncFailed: false, tmplWorkspaceSyncFailed: true} {
+			msg, err := templates{}.render(lang, name, failed)
+			if err != nil {
+				t.Fatalf("render(%s, %s) = %v", lang, name, err)
+			}
+			if got := strings.Contains(msg.Text, "apply: failed") || strings.Contains(msg.HTML, "apply: failed"); got != want {
+				t.Errorf("render(%s, %s) has error = %v; want %v", lang, name, got, want)
+			}
+		}
+	}
+}
+
+type testLinks struct{}
+
+func (testLinks) WorkspaceURL(id model.ID) string { return "https://sgs.example.com/ws/" + id.Hash() }
+func (testLinks) DocsURL() string                 { return "https://docs.example.com" }
+
+func TestSendSeparately(t *testing.T) {
+	t.Parallel()
+
+	var sent []string
+	s := &smtpService{
+		cfg:   Config{From: "SGS <no-reply@example.com>", Host: "smtp.example.com", Port: 25},
+		users: fakeUsers{langs: map[string]string{"bob": "ko"}},
+		sendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			m, err := mail.ReadMessage(bytes.NewReader(msg))
+			if err != nil {
+				t.Fatalf("ReadMessage() = %v", err)
+			}
+			sent = append(sent, strings.Join(to, ",")+" "+m.Header.Get("To"))
+			return nil
+		},
+	}
+
+	// recipients don't see each other's addresses, whatever their language
+	rcpts := []Recipient{
+		{Username: "alice", Email: "alice@home.example.com"},
+		{Username: "bob", Email: "bob@example.com"},
+		{Username: "carol", Email: "carol@example.com"},
+	}
+	data := newWorkspaceData(&model.Notification{Workspace: &model.Workspace{ID: 1}}, testLinks{})
+	if err := s.send(context.Background(), rcpts, tmplWorkspaceApproved, data); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	want := []string{
+		"alice@home.example.com alice@home.example.com",
+		"carol@example.com carol@example.com",
+		"bob@example.com bob@example.com",
+	}
+	if diff := cmp.Diff(want, sent); diff != "" {
+		t.Errorf("sent envelope and To mismatch\n%s", diff)
+	}
+}
+
+func TestMessageEncode(t *testing.T) {
+	t.Parallel()
+
+	msg := &message{
+		Subject: "[SGS] 워크스페이스 요청이 승인되었습니다",
+		Text:    "승인되었습니다.\n",
+		HTML:    "<p>승인되었습니다.</p>\n",
+	}
+	date := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	b, err := msg.encode("SGS <no-reply@example.com>", "a@example.com", date)
+	if err != nil {
+		t.Fatalf("encode() = %v", err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadMessage() = %v", err)
+	}
+	var dec mime.WordDecoder
+	subject, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q, %v; want %q", subject, err, msg.Subject)
+	}
+	if got, err := m.Header.Date(); err != nil || !got.Equal(date) {
+		t.Errorf("Date = %v, %v; want %v", got, err, date)
+	}
+	if id := m.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q; want <...@example.com>", id)
+	}
+	if got := m.Header.Get("To"); got != "a@example.com" {
+		t.Errorf("To = %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v; want multipart/alternative", mediaType, err)
+	}
+	var got []string
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+		// quoted-printable is decoded by the reader
+		content, _ := io.ReadAll(p)
+		got = append(got, p.Header.Get("Content-Type"), string(content))
+	}
+	// with CRLF line endings
+	want := []string{
+		"text/plain; charset=UTF-8", "승인되었습니다.\r\n",
+		"text/html; charset=UTF-8", "<p>승인되었습니다.</p>\r\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parts mismatch\n%s", diff)
+	}
+}
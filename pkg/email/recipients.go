@@ -0,0 +1,132 @@
+package email
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+)
+
+// nodegroups returns the nodegroups n concerns, the one of its workspace and
+// the one requested.
+func nodegroups(n *model.Notification) []model.Nodegroup {
+	ngs := []model.Nodegroup{n.Workspace.Nodegroup}
+	if req := n.Workspace.Request; req != nil && req.Nodegroup != n.Workspace.Nodegroup {
+		ngs = append(ngs, req.Nodegroup)
+	}
+	return ngs
+}
+
+// subscribers returns the subscribed admins, their subscriptions narrowed to the
+// nodegroups the policy still allows them, as of the groups they last logged
+// in with. Admins who lost their role since subscribing are skipped.
+func (s *smtpService) subscribers(ctx context.Context) ([]model.Subscriber, error) {
+	subscribers, err := s.mlSvc.ListSubscribers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing subscribers: %w", err)
+	}
+	usernames := make([]string, len(subscribers))
+	for i, sub := range subscribers {
+		usernames[i] = sub.Username
+	}
+	groups, err := s.users.Groups(ctx, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("looking up groups: %w", err)
+	}
+
+	var allowed []model.Subscriber
+	for _, sub := range subscribers {
+		user := &auth.User{Username: sub.Username, Email: sub.Email, Groups: groups[sub.Username]}
+		if !s.policy.Can(user, auth.ActionSubscribe, nil) {
+			continue
+		}
+		var ngs []model.Nodegroup
+		for _, ng := range model.Nodegroups {
+			if (len(sub.Nodegroups) == 0 || slices.Contains(sub.Nodegroups, ng)) &&
+				s.policy.Can(user, auth.ActionView, &model.Workspace{Nodegroup: ng}) {
+				ngs = append(ngs, ng)
+			}
+		}
+		if len(ngs) == 0 {
+			continue
+		}
+		sub.Nodegroups = ngs
+		allowed = append(allowed, sub)
+	}
+	return allowed, nil
+}
+
+// adminRecipients returns the admins subscribed to n as it happens.
+func (s *smtpService) adminRecipients(ctx context.Context, n *model.Notification) ([]Recipient, error) {
+	subscribers, err := s.subscribers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rcpts []Recipient
+	for _, sub := range subscribers {
+		if sub.Mode != model.ModeImmediate || !sub.Wants(n.Event, nodegroups(n)...) {
+			continue
+		}
+		rcpts = append(rcpts, Recipient{Username: sub.Username, Email: sub.Email})
+	}
+	return rcpts, nil
+}
+
+// audience returns the users notified of n, before their preferences.
+// Pending invitees are only notified of their invitation.
+func audience(n *model.Notification) []string {
+	var usernames []string
+	switch n.Event {
+	case model.EventWorkspaceInvited:
+		return []string{n.Username}
+	case model.EventMemberRemoved:
+		usernames = append(usernames, n.Username)
+	}
+	for _, u := range n.Workspace.Users {
+		if !u.IsAccepted() || (n.Event == model.EventMemberAdded && u.Username == n.Username) {
+			continue
+		}
+		usernames = append(usernames, u.Username)
+	}
+	return usernames
+}
+
+// userRecipients returns the users who want to be emailed about n, at their
+// preferred address, or the one they accepted the workspace or last logged in
+// with. Users without any are skipped.
+func (s *smtpService) userRecipients(ctx context.Context, n *model.Notification) ([]Recipient, error) {
+	usernames := audience(n)
+	prefs, err := s.users.NotificationPreferences(ctx, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("looking up notification preferences: %w", err)
+	}
+	logins, err := s.users.Emails(ctx, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("looking up emails: %w", err)
+	}
+	accepted := make(map[string]string, len(n.Workspace.Users))
+	for _, u := range n.Workspace.Users {
+		accepted[u.Username] = u.Email
+	}
+
+	var rcpts []Recipient
+	for _, username := range usernames {
+		p := prefs[username]
+		if !p.Wants(n.Event, model.ChannelEmail) {
+			continue
+		}
+		var preferred string
+		if p != nil {
+			preferred = p.Email
+		}
+		addr := cmp.Or(preferred, accepted[username], logins[username])
+		if addr == "" {
+			continue
+		}
+		rcpts = append(rcpts, Recipient{Username: username, Email: addr})
+	}
+	return rcpts, nil
+}
@@ -0,0 +1,181 @@
+package email
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/auth"
+)
+
+// fakeUsers has the preferences, login addresses, and groups of users.
+type fakeUsers struct {
+	model.UserService
+	prefs  map[string]*model.NotificationPreferences
+	emails map[string]string
+	groups map[string][]string
+	langs  map[string]string
+}
+
+func (u fakeUsers) NotificationPreferences(ctx context.Context, usernames []string) (map[string]*model.NotificationPreferences, error) {
+	return u.prefs, nil
+}
+
+func (u fakeUsers) Emails(ctx context.Context, usernames []string) (map[string]string, error) {
+	return u.emails, nil
+}
+
+func (u fakeUsers) Languages(ctx context.Context, usernames []string) (map[string]string, error) {
+	return u.langs, nil
+}
+
+func (u fakeUsers) Groups(ctx context.Context, usernames []string) (map[string][]string, error) {
+	return u.groups, nil
+}
+
+func TestUserRecipients(t *testing.T) {
+	t.Parallel()
+
+	ws := &model.Workspace{ID: 1, Users: []model.WorkspaceUser{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob", Email: "bob@example.com"},
+		{Username: "carol"}, // pending invitation
+	}}
+	users := fakeUsers{
+		prefs: map[string]*model.NotificationPreferences{
+			"alice": {Email: "alice@home.example.com"},
+			"bob": {Enabled: map[model.NotificationEvent]map[model.NotificationChannel]bool{
+				model.EventMemberAdded: {model.ChannelEmail: false},
+			}},
+		},
+		emails: map[string]string{"carol": "carol@example.com", "dave": "dave@example.com"},
+	}
+	s := &smtpService{users: users}
+
+	tests := []struct {
+		event    model.NotificationEvent
+		username string
+		want     []Recipient
+	}{
+		{model.EventWorkspaceApproved, "", []Recipient{
+			{Username: "alice", Email: "alice@home.example.com"},
+			{Username: "bob", Email: "bob@example.com"},
+		}},
+		{model.EventWorkspaceInvited, "carol", []Recipient{
+			{Username: "carol", Email: "carol@example.com"},
+		}},
+		// bob opted out
+		{model.EventMemberAdded, "carol", []Recipient{
+			{Username: "alice", Email: "alice@home.example.com"},
+		}},
+		{model.EventMemberRemoved, "dave", []Recipient{
+			{Username: "dave", Email: "dave@example.com"},
+			{Username: "alice", Email: "alice@home.example.com"},
+			{Username: "bob", Email: "bob@example.com"},
+		}},
+	}
+	for _, tt := range tests {
+		n := &model.Notification{Event: tt.event, Workspace: ws, Username: tt.username}
+		got, err := s.userRecipients(context.Background(), n)
+		if err != nil {
+			t.Fatalf("userRecipients(%s) = %v", tt.event, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("userRecipients(%s) mismatch\n%s", tt.event, diff)
+		}
+	}
+}
+
+type fakeMailingList struct {
+	model.MailingListService
+	subscribers []model.Subscriber
+}
+
+func (ml fakeMailingList) ListSubscribers(ctx context.Context) ([]model.Subscriber, error) {
+	return ml.subscribers, nil
+}
+
+func TestAdminRecipients(t *testing.T) {
+	t.Parallel()
+
+	s := &smtpService{
+		mlSvc: fakeMailingList{subscribers: []model.Subscriber{
+			{Username: "all", Email: "all@example.com", Mode: model.ModeImmediate},
+			{Username: "digest", Email: "digest@example.com", Mode: model.ModeDigest},
+			{Username: "grad", Email: "grad@example.com", Mode: model.ModeImmediate,
+				Nodegroups: []model.Nodegroup{model.NodegroupGraduate}},
+			{Username: "failures", Email: "failures@example.com", Mode: model.ModeImmediate,
+				Events: []model.NotificationEvent{model.EventWorkspaceSyncFailed}},
+			// subscribed to all, but only admin of undergraduate workspaces
+			{Username: "ta", Email: "ta@example.com", Mode: model.ModeImmediate},
+			// no longer a reviewer
+			{Username: "former", Email: "former@example.com", Mode: model.ModeImmediate},
+		}},
+		users: fakeUsers{groups: map[string][]string{
+			"all":      {"reviewers"},
+			"digest":   {"reviewers"},
+			"grad":     {"grad-admins"},
+			"failures": {"reviewers"},
+			"ta":       {"ta"},
+			"former":   {"students"},
+		}},
+		policy: auth.NewPolicy(auth.Config{
+			ReviewerGroups: []string{"reviewers"},
+			NodegroupAdmins: []auth.NodegroupAdmin{
+				{Group: "grad-admins", Nodegroups: []string{string(model.NodegroupGraduate)}},
+				{Group: "ta", Nodegroups: []string{string(model.NodegroupUndergraduate)}},
+			},
+		}),
+	}
+
+	undergrad := &model.Workspace{ID: 1, Nodegroup: model.NodegroupUndergraduate}
+	grad := &model.Workspace{ID: 2, Nodegroup: model.NodegroupGraduate}
+	moving := &model.Workspace{ID: 3, Nodegroup: model.NodegroupUndergraduate,
+		Request: &model.WorkspaceUpdate{Nodegroup: model.NodegroupGraduate}}
+	tests := []struct {
+		event model.NotificationEvent
+		ws    *model.Workspace
+		want  []string
+	}{
+		{model.EventWorkspaceRequested, undergrad, []string{"all", "ta"}},
+		{model.EventWorkspaceRequested, grad, []string{"all", "grad"}},
+		{model.EventWorkspaceSyncFailed, undergrad, []string{"all", "failures", "ta"}},
+		// requests to move concern the admins of both nodegroups
+		{model.EventWorkspaceUpdateRequested, moving, []string{"all", "grad", "ta"}},
+	}
+	for _, tt := range tests {
+		rcpts, err := s.adminRecipients(context.Background(), &model.Notification{Event: tt.event, Workspace: tt.ws})
+		if err != nil {
+			t.Fatalf("adminRecipients(%s) = %v", tt.event, err)
+		}
+		var got []string
+		for _, r := range rcpts {
+			got = append(got, r.Username)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("adminRecipients(%s) mismatch\n%s", tt.event, diff)
+		}
+	}
+
+	// digests are sent to the same subscribers, of the nodegroups allowed
+	subs, err := s.subscribers(context.Background())
+	if err != nil {
+		t.Fatalf("subscribers() = %v", err)
+	}
+	got := make(map[string][]model.Nodegroup)
+	for _, sub := range subs {
+		got[sub.Username] = sub.Nodegroups
+	}
+	want := map[string][]model.Nodegroup{
+		"all":      model.Nodegroups,
+		"digest":   model.Nodegroups,
+		"grad":     {model.NodegroupGraduate},
+		"failures": model.Nodegroups,
+		"ta":       {model.NodegroupUndergraduate},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("subscribers() nodegroups mismatch\n%s", diff)
+	}
+}
@@ -0,0 +1,159 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"text/template"
+
+	"golang.org/x/text/language"
+)
+
+// Templates of each message, in templates/<lang>/<name>.txt and .html. The
+// text template defines the "subject" as well. Templates may be overridden by
+// files of the same path in Config.TemplateDir.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+const (
+	// to admins
+	tmplWorkspaceRequest       = "workspace_request"
+	tmplWorkspaceUpdateRequest = "workspace_update_request"
+	tmplWorkspaceSyncFailed    = "workspace_sync_failed"
+	tmplRequestReminder        = "request_reminder"
+	tmplDigest                 = "digest"
+
+	// to users
+	tmplRequestFiled        = "request_filed"
+	tmplUpdateRequestFiled  = "update_request_filed"
+	tmplWorkspaceApproved   = "workspace_approved"
+	tmplWorkspaceDenied     = "workspace_denied"
+	tmplWorkspaceInvitation = "workspace_invitation"
+	tmplMemberAdded         = "member_added"
+	tmplMemberRemoved       = "member_removed"
+	tmplSyncFailed          = "sync_failed"
+	tmplEmailConfirmation   = "email_confirmation"
+)
+
+var templateNames = []string{
+	tmplWorkspaceRequest, tmplRequestFiled, tmplUpdateRequestFiled,
+	tmplWorkspaceApproved, tmplWorkspaceDenied,
+	tmplWorkspaceInvitation, tmplMemberAdded, tmplMemberRemoved,
+	tmplSyncFailed, tmplRequestReminder, tmplDigest,
+	tmplWorkspaceUpdateRequest, tmplWorkspaceSyncFailed,
+	tmplEmailConfirmation,
+}
+
+// DefaultLanguage is used for users without a preferred language, and for
+// templates missing in their language.
+const DefaultLanguage = "en"
+
+// Languages emails are localised in.
+var Languages = []string{"en", "ko"}
+
+var languageMatcher = language.NewMatcher([]language.Tag{language.English, language.Korean})
+
+// MatchLanguage returns the supported language best matching the
+// Accept-Language header value.
+func MatchLanguage(acceptLanguage string) string {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := languageMatcher.Match(tags...)
+	return Languages[i]
+}
+
+// message is a rendered email.
+type message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type templates struct {
+	// overrides, may be empty
+	dir string
+}
+
+// readFile reads the template of lang, falling back to the default language.
+// Overrides take precedence over the embedded templates.
+func (t templates) readFile(lang, name string) ([]byte, error) {
+	for _, l := range []string{lang, DefaultLanguage} {
+		p := path.Join(l, name)
+		if t.dir != "" {
+			b, err := os.ReadFile(filepath.Join(t.dir, filepath.FromSlash(p)))
+			if err == nil || !errors.Is(err, fs.ErrNotExist) {
+				return b, err
+			}
+		}
+		b, err := embeddedTemplates.ReadFile(path.Join("templates", p))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return b, err
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (t templates) parse(lang, name string) (*template.Template, *htmltemplate.Template, error) {
+	text, err := t.readFile(lang, name+".txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	textTmpl, err := template.New(name).Parse(string(text))
+	if err != nil {
+		return nil, nil, err
+	}
+	if textTmpl.Lookup("subject") == nil {
+		return nil, nil, errors.New(name + ".txt: subject is not defined")
+	}
+
+	html, err := t.readFile(lang, name+".html")
+	if err != nil {
+		return nil, nil, err
+	}
+	htmlTmpl, err := htmltemplate.New(name).Parse(string(html))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return textTmpl, htmlTmpl, nil
+}
+
+// check parses all templates.
+func (t templates) check() error {
+	var errs []error
+	for _, lang := range Languages {
+		for _, name := range templateNames {
+			if _, _, err := t.parse(lang, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// render the message name in lang. Templates are parsed every time, so that
+// changes to overrides apply immediately.
+func (t templates) render(lang, name string, data any) (*message, error) {
+	textTmpl, htmlTmpl, err := t.parse(lang, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &message{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
@@ -0,0 +1,267 @@
+// Package notify delivers the notifications written to the outbox.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/secret"
+)
+
+type Config struct {
+	// The outbox is checked for due notifications every PollInterval.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// Failed deliveries are retried after RetryBackoff, doubling up to
+	// RetryMaxBackoff, and given up on after MaxAttempts.
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	RetryMaxBackoff time.Duration `mapstructure:"retry_max_backoff"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	// Delivered notifications are kept for Retention, for the admin view.
+	Retention time.Duration `mapstructure:"retention"`
+
+	// Admins subscribed to the digest are emailed it daily at DigestTime
+	// (HH:MM, in the local time zone). Admins are reminded of requests
+	// pending for longer than RemindAfter, every RemindAfter. Zero disables
+	// reminders.
+	DigestTime  string        `mapstructure:"digest_time"`
+	RemindAfter time.Duration `mapstructure:"remind_after"`
+
+	// Chat webhooks posted new and stale requests, approvals, and
+	// provisioning failures.
+	Webhooks []WebhookConfig `mapstructure:"webhooks"`
+}
+
+// WebhookConfig configures an incoming webhook of a chat service.
+type WebhookConfig struct {
+	URL string `mapstructure:"url"`
+	// URLFile is read instead of URL, if set. It is read once, so rotating
+	// it requires a restart, not SIGHUP.
+	URLFile string `mapstructure:"url_file"`
+	// Message format: slack, mattermost, or discord.
+	Format WebhookFormat `mapstructure:"format"`
+	// Only workspaces in Nodegroups are posted. Empty posts all.
+	Nodegroups []string `mapstructure:"nodegroups"`
+}
+
+func (c *Config) Bind() {
+	viper.BindEnv("notify.poll_interval", "SGS_NOTIFY_POLL_INTERVAL")
+	viper.BindEnv("notify.retry_backoff", "SGS_NOTIFY_RETRY_BACKOFF")
+	viper.BindEnv("notify.retry_max_backoff", "SGS_NOTIFY_RETRY_MAX_BACKOFF")
+	viper.BindEnv("notify.max_attempts", "SGS_NOTIFY_MAX_ATTEMPTS")
+	viper.BindEnv("notify.retention", "SGS_NOTIFY_RETENTION")
+	viper.BindEnv("notify.digest_time", "SGS_NOTIFY_DIGEST_TIME")
+	viper.BindEnv("notify.remind_after", "SGS_NOTIFY_REMIND_AFTER")
+	// JSON-encoded list of chat webhooks
+	viper.BindEnv("notify.webhooks", "SGS_NOTIFY_WEBHOOKS")
+
+	viper.SetDefault("notify.poll_interval", 10*time.Second)
+	viper.SetDefault("notify.retry_backoff", 30*time.Second)
+	viper.SetDefault("notify.retry_max_backoff", time.Hour)
+	viper.SetDefault("notify.max_attempts", 10)
+	viper.SetDefault("notify.retention", 30*24*time.Hour)
+	viper.SetDefault("notify.digest_time", "09:00")
+	viper.SetDefault("notify.remind_after", 72*time.Hour)
+}
+
+func (c *Config) Validate() error {
+	var err error
+	if c.PollInterval <= 0 {
+		err = errors.Join(err, errors.New("poll_interval must be positive"))
+	}
+	if c.RetryBackoff <= 0 {
+		err = errors.Join(err, errors.New("retry_backoff must be positive"))
+	}
+	if c.RetryMaxBackoff < c.RetryBackoff {
+		err = errors.Join(err, errors.New("retry_max_backoff must not be less than retry_backoff"))
+	}
+	if c.MaxAttempts <= 0 {
+		err = errors.Join(err, errors.New("max_attempts must be positive"))
+	}
+	if c.Retention <= 0 {
+		err = errors.Join(err, errors.New("retention must be positive"))
+	}
+	if _, terr := time.Parse(digestTimeLayout, c.DigestTime); terr != nil {
+		err = errors.Join(err, fmt.Errorf("invalid digest_time %q, want HH:MM", c.DigestTime))
+	}
+	if c.RemindAfter < 0 {
+		err = errors.Join(err, errors.New("remind_after must not be negative"))
+	}
+	for i, wh := range c.Webhooks {
+		if serr := secret.Load(&c.Webhooks[i].URL, wh.URLFile); serr != nil {
+			err = errors.Join(err, fmt.Errorf("webhooks[%d]: url_file: %w", i, serr))
+		} else if u, uerr := url.Parse(c.Webhooks[i].URL); uerr != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			err = errors.Join(err, fmt.Errorf("webhooks[%d]: invalid url", i))
+		}
+		if !slices.Contains(WebhookFormats, wh.Format) {
+			err = errors.Join(err, fmt.Errorf("webhooks[%d]: unknown format %q", i, wh.Format))
+		}
+		for _, ng := range wh.Nodegroups {
+			if !model.Nodegroup(ng).Valid() {
+				err = errors.Join(err, fmt.Errorf("webhooks[%d]: unknown nodegroup %q", i, ng))
+			}
+		}
+	}
+	return err
+}
+
+// Redacted returns a copy of c with webhook URLs, which embed their tokens,
+// redacted.
+func (c Config) Redacted() Config {
+	c.Webhooks = slices.Clone(c.Webhooks)
+	for i := range c.Webhooks {
+		if c.Webhooks[i].URL != "" {
+			c.Webhooks[i].URL = "REDACTED"
+		}
+	}
+	return c
+}
+
+const (
+	// notifications claimed at once
+	claimLimit = 20
+	// Claimed notifications are left to other replicas after claimLease, so a
+	// crashed sender delays them only as long. Deliveries must not take longer.
+	claimLease = 5 * time.Minute
+	// delivered notifications are deleted this often
+	purgeInterval = time.Hour
+)
+
+// Notifier delivers notifications through a channel, to whoever it deems
+// concerned. email.Service is one.
+type Notifier interface {
+	Notify(ctx context.Context, n *model.Notification) error
+}
+
+// Channel is a notifier, named so that the outbox can record which channels
+// delivered a notification. Names must be stable across restarts.
+type Channel struct {
+	Name     string
+	Notifier Notifier
+}
+
+// Sender delivers notifications from the outbox through every channel. Every
+// replica may run one, claimed notifications are skipped by the others.
+//
+// Failed deliveries are retried only through the channels that failed. Delivery
+// is still at least once: a channel delivers a notification again if recording
+// it fails, or if it was delivered to only some of the recipients.
+type Sender struct {
+	cfg      Config
+	outbox   model.OutboxService
+	channels []Channel
+
+	lastPurge time.Time
+}
+
+// NewSender creates a sender delivering notifications through channels.
+func NewSender(cfg Config, outbox model.OutboxService, channels ...Channel) *Sender {
+	return &Sender{
+		cfg:      cfg,
+		outbox:   outbox,
+		channels: channels,
+	}
+}
+
+// Run delivers due notifications every PollInterval, until ctx is done.
+func (s *Sender) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.deliverDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Println("notify:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deliverDue delivers all notifications due at now.
+func (s *Sender) deliverDue(ctx context.Context, now time.Time) error {
+	if now.Sub(s.lastPurge) >= purgeInterval {
+		if err := s.outbox.DeleteSentNotifications(ctx, now.Add(-s.cfg.Retention)); err != nil {
+			return fmt.Errorf("deleting delivered notifications: %w", err)
+		}
+		s.lastPurge = now
+	}
+
+	for {
+		ns, err := s.outbox.ClaimNotifications(ctx, now, claimLease, claimLimit)
+		if err != nil {
+			return fmt.Errorf("claiming notifications: %w", err)
+		}
+		for _, n := range ns {
+			if err := s.process(ctx, n, now); err != nil {
+				return err
+			}
+		}
+		if len(ns) < claimLimit {
+			return nil
+		}
+	}
+}
+
+// process delivers n, and records the outcome.
+func (s *Sender) process(ctx context.Context, n *model.Notification, now time.Time) error {
+	derr := s.deliver(ctx, n)
+	if derr == nil {
+		if err := s.outbox.MarkSent(ctx, n.ID, now); err != nil {
+			return fmt.Errorf("marking notification %d sent: %w", n.ID, err)
+		}
+		return nil
+	}
+
+	var next time.Time
+	if attempts := n.Attempts + 1; attempts < s.cfg.MaxAttempts {
+		next = now.Add(s.retryDelay(attempts))
+		log.Printf("notify: delivering %s of workspace %s failed, retrying at %v: %v",
+			n.Event, n.Workspace.ID.Hash(), next.Format(time.RFC3339), derr)
+	} else {
+		log.Printf("notify: delivering %s of workspace %s failed %d times, giving up: %v",
+			n.Event, n.Workspace.ID.Hash(), attempts, derr)
+	}
+	if err := s.outbox.MarkFailed(ctx, n.ID, derr.Error(), next); err != nil {
+		return fmt.Errorf("marking notification %d failed: %w", n.ID, err)
+	}
+	return nil
+}
+
+// retryDelay is the exponential backoff after the given number of failed
+// attempts, capped at RetryMaxBackoff.
+func (s *Sender) retryDelay(attempts int) time.Duration {
+	d := s.cfg.RetryBackoff
+	for i := 1; i < attempts && d < s.cfg.RetryMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.cfg.RetryMaxBackoff)
+}
+
+// deliver delivers n through the channels that have not yet, recording those
+// that succeed.
+func (s *Sender) deliver(ctx context.Context, n *model.Notification) error {
+	var errs []error
+	for _, ch := range s.channels {
+		if slices.Contains(n.Delivered, ch.Name) {
+			continue
+		}
+		if err := ch.Notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ch.Name, err))
+			continue
+		}
+		if err := s.outbox.MarkDelivered(ctx, n.ID, ch.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: recording delivery: %w", ch.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// fakeOutbox keeps notifications and scheduled jobs in memory.
+type fakeOutbox struct {
+	ns   []*model.Notification
+	jobs map[string]time.Time
+	// reminders enqueued, of every call
+	reminded []time.Time
+}
+
+func (o *fakeOutbox) ClaimNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.Notification, error) {
+	var claimed []*model.Notification
+	for _, n := range o.ns {
+		if len(claimed) == limit {
+			break
+		}
+		if n.SentAt.IsZero() && !n.Dead && !n.NextAttemptAt.After(now) {
+			n.NextAttemptAt = now.Add(lease)
+			c := *n
+			claimed = append(claimed, &c)
+		}
+	}
+	return claimed, nil
+}
+
+func (o *fakeOutbox) get(id model.ID) (*model.Notification, error) {
+	for _, n := range o.ns {
+		if n.ID == id {
+			return n, nil
+		}
+	}
+	return nil, model.ErrNotFound
+}
+
+func (o *fakeOutbox) MarkDelivered(ctx context.Context, id model.ID, channel string) error {
+	n, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(n.Delivered, channel) {
+		n.Delivered = append(n.Delivered, channel)
+	}
+	return nil
+}
+
+func (o *fakeOutbox) MarkSent(ctx context.Context, id model.ID, t time.Time) error {
+	n, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	n.SentAt = t
+	return nil
+}
+
+func (o *fakeOutbox) MarkFailed(ctx context.Context, id model.ID, reason string, next time.Time) error {
+	n, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	n.Attempts++
+	n.LastError = reason
+	if next.IsZero() {
+		n.Dead = true
+	} else {
+		n.NextAttemptAt = next
+	}
+	return nil
+}
+
+func (o *fakeOutbox) ListNotifications(ctx context.Context, limit int) ([]*model.Notification, error) {
+	return o.ns, nil
+}
+
+func (o *fakeOutbox) RetryNotification(ctx context.Context, id model.ID) error {
+	n, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	n.Attempts, n.Dead = 0, false
+	return nil
+}
+
+func (o *fakeOutbox) DeleteSentNotifications(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func (o *fakeOutbox) EnqueueReminders(ctx context.Context, now time.Time, age time.Duration) (int, error) {
+	o.reminded = append(o.reminded, now)
+	return 0, nil
+}
+
+func (o *fakeOutbox) ClaimJob(ctx context.Context, name string, due time.Time) (bool, error) {
+	if last, ok := o.jobs[name]; ok && !last.Before(due) {
+		return false, nil
+	}
+	if o.jobs == nil {
+		o.jobs = make(map[string]time.Time)
+	}
+	o.jobs[name] = due
+	return true, nil
+}
+
+// fakeNotifier records delivered notifications, failing while err is set.
+type fakeNotifier struct {
+	sent []string
+	err  error
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n *model.Notification) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, fmt.Sprintf("%s of %s", n.Event, n.Workspace.ID.Hash()))
+	return nil
+}
+
+func TestSender(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{
+		PollInterval:    time.Second,
+		RetryBackoff:    time.Minute,
+		RetryMaxBackoff: 3 * time.Minute,
+		MaxAttempts:     4,
+		Retention:       time.Hour,
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ws := &model.Workspace{ID: 1, Users: []model.WorkspaceUser{{Username: "alice", Email: "alice@example.com"}}}
+
+	outbox := &fakeOutbox{ns: []*model.Notification{
+		{ID: 1, Event: model.EventWorkspaceRequested, Workspace: ws, NextAttemptAt: now},
+		{ID: 2, Event: model.EventWorkspaceApproved, Workspace: ws, NextAttemptAt: now},
+		{ID: 3, Event: model.EventWorkspaceDenied, Workspace: ws, NextAttemptAt: now.Add(time.Hour)},
+	}}
+	ok, failing := &fakeNotifier{}, &fakeNotifier{}
+	s := NewSender(cfg, outbox, Channel{Name: "ok", Notifier: ok}, Channel{Name: "failing", Notifier: failing})
+
+	ctx := context.Background()
+	if err := s.deliverDue(ctx, now); err != nil {
+		t.Fatalf("deliverDue() = %v", err)
+	}
+	want := []string{
+		"workspace_requested of " + ws.ID.Hash(),
+		"workspace_approved of " + ws.ID.Hash(),
+	}
+	if diff := cmp.Diff(want, ok.sent); diff != "" {
+		t.Errorf("sent mismatch\n%s", diff)
+	}
+	if outbox.ns[0].SentAt != now || outbox.ns[1].SentAt != now || !outbox.ns[2].SentAt.IsZero() {
+		t.Errorf("only due notifications should be marked sent")
+	}
+
+	// failures of any channel are retried with backoff, until given up on,
+	// through only the failed channels
+	failing.err = errors.New("smtp down")
+	n := outbox.ns[2]
+	var delays []time.Duration
+	for at := now.Add(time.Hour); !n.Dead; at = n.NextAttemptAt {
+		if err := s.deliverDue(ctx, at); err != nil {
+			t.Fatalf("deliverDue() = %v", err)
+		}
+		if !n.Dead {
+			delays = append(delays, n.NextAttemptAt.Sub(at))
+		}
+	}
+	wantDelays := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	if diff := cmp.Diff(wantDelays, delays); diff != "" {
+		t.Errorf("retry delays mismatch\n%s", diff)
+	}
+	if n.Attempts != cfg.MaxAttempts || n.LastError != "failing: smtp down" {
+		t.Errorf("dead notification = %+v; want %d attempts, last error %q", n, cfg.MaxAttempts, "failing: smtp down")
+	}
+	if got := len(ok.sent); got != 3 {
+		t.Errorf("ok channel sent %d notifications; want 3, the failed one once", got)
+	}
+
+	// dead notifications are only retried by admins
+	failing.err = nil
+	if err := s.deliverDue(ctx, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("deliverDue() = %v", err)
+	}
+	if !n.SentAt.IsZero() {
+		t.Errorf("dead notification delivered without retry")
+	}
+	outbox.RetryNotification(ctx, n.ID)
+	if err := s.deliverDue(ctx, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("deliverDue() = %v", err)
+	}
+	if n.SentAt.IsZero() {
+		t.Errorf("retried notification not delivered")
+	}
+	if len(ok.sent) != 3 || len(failing.sent) != 3 {
+		t.Errorf("sent %d, %d notifications; want each delivered once per channel", len(ok.sent), len(failing.sent))
+	}
+}
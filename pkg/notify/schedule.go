@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/email"
+)
+
+const (
+	digestTimeLayout = "15:04"
+	// name of the digest in the scheduled jobs
+	digestJob = "digest"
+	// reminders and the digest are checked for this often
+	scheduleInterval = time.Minute
+)
+
+// Scheduler emails the daily digest to admins subscribed to it, and enqueues
+// reminders of stale requests. Every replica may run one, each digest and
+// reminder is sent by only one of them.
+//
+// Unlike notifications, digests are sent at most once: a digest that fails to
+// send is not retried, the next one is sent the day after. Digests missed
+// while no replica was running are sent once one is.
+type Scheduler struct {
+	cfg      Config
+	outbox   model.OutboxService
+	wsSvc    model.WorkspaceService
+	emailSvc email.Service
+}
+
+// NewScheduler creates a scheduler summarising the workspaces of wsSvc.
+func NewScheduler(cfg Config, outbox model.OutboxService, wsSvc model.WorkspaceService, emailSvc email.Service) *Scheduler {
+	return &Scheduler{
+		cfg:      cfg,
+		outbox:   outbox,
+		wsSvc:    wsSvc,
+		emailSvc: emailSvc,
+	}
+}
+
+// Run sends due reminders and digests every minute, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.runDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Println("notify:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runDue enqueues the reminders, and sends the digest, due at now.
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) error {
+	var errs []error
+	if s.cfg.RemindAfter > 0 {
+		n, err := s.outbox.EnqueueReminders(ctx, now, s.cfg.RemindAfter)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("enqueueing reminders: %w", err))
+		} else if n > 0 {
+			log.Printf("notify: reminding admins of %d stale requests", n)
+		}
+	}
+
+	claimed, err := s.outbox.ClaimJob(ctx, digestJob, s.digestDue(now))
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("claiming digest: %w", err))...)
+	}
+	if claimed {
+		if err := s.sendDigest(ctx, now); err != nil {
+			errs = append(errs, fmt.Errorf("sending digest: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Scheduler) sendDigest(ctx context.Context, now time.Time) error {
+	wss, err := s.wsSvc.ListAllWorkspaces(ctx)
+	if err != nil {
+		return err
+	}
+	return s.emailSvc.SendDigest(ctx, wss, now)
+}
+
+// digestDue returns the latest DigestTime at or before now.
+func (s *Scheduler) digestDue(now time.Time) time.Time {
+	t, _ := time.Parse(digestTimeLayout, s.cfg.DigestTime)
+	due := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+	return due
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/email"
+)
+
+type fakeWorkspaces struct {
+	model.WorkspaceService
+}
+
+func (fakeWorkspaces) ListAllWorkspaces(ctx context.Context) ([]*model.Workspace, error) {
+	return []*model.Workspace{{ID: 1}}, nil
+}
+
+// fakeDigests records the times digests were sent at.
+type fakeDigests struct {
+	email.Service
+	sent []time.Time
+}
+
+func (d *fakeDigests) SendDigest(ctx context.Context, wss []*model.Workspace, now time.Time) error {
+	d.sent = append(d.sent, now)
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	kst := time.FixedZone("KST", 9*60*60)
+	cfg := Config{DigestTime: "09:00", RemindAfter: 72 * time.Hour}
+	outbox, digests := &fakeOutbox{}, &fakeDigests{}
+	s := NewScheduler(cfg, outbox, fakeWorkspaces{}, digests)
+
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, kst)
+	}
+	// the first digest is sent right away, then daily at 09:00
+	times := []time.Time{at(1, 8, 0), at(1, 8, 59), at(1, 9, 0), at(1, 9, 1), at(1, 23, 0), at(2, 0, 0), at(2, 9, 30), at(4, 12, 0)}
+	for _, now := range times {
+		if err := s.runDue(context.Background(), now); err != nil {
+			t.Fatalf("runDue(%v) = %v", now, err)
+		}
+	}
+
+	want := []time.Time{at(1, 8, 0), at(1, 9, 0), at(2, 9, 30), at(4, 12, 0)}
+	if diff := cmp.Diff(want, digests.sent); diff != "" {
+		t.Errorf("digests mismatch\n%s", diff)
+	}
+	// reminders are checked for every time
+	if diff := cmp.Diff(times, outbox.reminded); diff != "" {
+		t.Errorf("reminders mismatch\n%s", diff)
+	}
+}
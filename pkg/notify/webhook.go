@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// WebhookFormat is the message format of a chat service.
+type WebhookFormat string
+
+const (
+	FormatSlack      WebhookFormat = "slack"
+	FormatMattermost WebhookFormat = "mattermost"
+	FormatDiscord    WebhookFormat = "discord"
+)
+
+var WebhookFormats = []WebhookFormat{FormatSlack, FormatMattermost, FormatDiscord}
+
+const (
+	webhookTimeout     = 10 * time.Second
+	maxWebhookResponse = 1 << 10
+)
+
+// webhookEvents are the events posted to chat webhooks.
+var webhookEvents = []model.NotificationEvent{
+	model.EventWorkspaceRequested,
+	model.EventWorkspaceApproved,
+	model.EventWorkspaceSyncFailed,
+	model.EventRequestStale,
+}
+
+// Links are the absolute URLs linked from chat messages.
+type Links interface {
+	WorkspaceURL(id model.ID) string
+}
+
+// Webhook posts new and stale requests, approvals, and provisioning failures
+// to an incoming webhook of a chat service.
+type Webhook struct {
+	URL    string
+	Format WebhookFormat
+	// Only workspaces in Nodegroups are posted. Empty posts all.
+	Nodegroups []model.Nodegroup
+	Links      Links
+	Client     *http.Client
+}
+
+var _ Notifier = (*Webhook)(nil)
+
+// NewWebhooks creates the channels of the configured webhooks, linking to
+// links. They are named after a hash of their URL, which embeds a token.
+func NewWebhooks(cfgs []WebhookConfig, links Links) []Channel {
+	whs := make([]Channel, len(cfgs))
+	for i, cfg := range cfgs {
+		ngs := make([]model.Nodegroup, len(cfg.Nodegroups))
+		for j, ng := range cfg.Nodegroups {
+			ngs[j] = model.Nodegroup(ng)
+		}
+		h := sha256.Sum256([]byte(cfg.URL))
+		whs[i] = Channel{
+			Name:     "webhook-" + hex.EncodeToString(h[:4]),
+			Notifier: &Webhook{URL: cfg.URL, Format: cfg.Format, Nodegroups: ngs, Links: links},
+		}
+	}
+	return whs
+}
+
+func (wh *Webhook) Notify(ctx context.Context, n *model.Notification) error {
+	if !slices.Contains(webhookEvents, n.Event) {
+		return nil
+	}
+	if len(wh.Nodegroups) > 0 && !slices.Contains(wh.Nodegroups, n.Workspace.Nodegroup) {
+		return nil
+	}
+
+	// Discord names the field differently, the others take the same payload
+	field := "text"
+	if wh.Format == FormatDiscord {
+		field = "content"
+	}
+	b, err := json.Marshal(map[string]string{field: wh.message(n)})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// leave out the URL, which embeds the token of the webhook
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("chat webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+		return fmt.Errorf("chat webhook: status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// message formats n as a single line of markup.
+func (wh *Webhook) message(n *model.Notification) string {
+	ws := n.Workspace
+	link := wh.link(ws.ID.Hash(), wh.Links.WorkspaceURL(ws.ID))
+	ng := wh.escape(string(ws.Nodegroup))
+
+	switch n.Event {
+	case model.EventWorkspaceRequested:
+		return fmt.Sprintf("New workspace request %s in %s by %s, for %d GPUs",
+			link, ng, wh.escape(n.Username), ws.Quotas[model.ResGPURequest])
+	case model.EventWorkspaceApproved:
+		return fmt.Sprintf("Workspace %s in %s was approved, and is ready", link, ng)
+	case model.EventWorkspaceSyncFailed:
+		return fmt.Sprintf("Provisioning workspace %s in %s failed: %s", link, ng, wh.escape(ws.Provision.Message))
+	case model.EventRequestStale:
+		if ws.Request != nil {
+			return fmt.Sprintf("Workspace request %s in %s by %s is still pending, since %s",
+				link, wh.escape(string(ws.Request.Nodegroup)), wh.escape(ws.Request.ByUser),
+				ws.Request.RequestedAt.Format("2006-01-02 15:04"))
+		}
+	}
+	return fmt.Sprintf("%s of workspace %s", n.Event, link)
+}
+
+func (wh *Webhook) link(text, href string) string {
+	if wh.Format == FormatSlack {
+		return "<" + href + "|" + wh.escape(text) + ">"
+	}
+	return "[" + wh.escape(text) + "](" + href + ")"
+}
+
+var (
+	slackEscaper    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "~", `\~`,
+		"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "|", `\|`,
+	)
+)
+
+// escape text of a message, so that it is not interpreted as markup.
+func (wh *Webhook) escape(text string) string {
+	if wh.Format == FormatSlack {
+		return slackEscaper.Replace(text)
+	}
+	return markdownEscaper.Replace(text)
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+type testLinks struct{}
+
+func (testLinks) WorkspaceURL(id model.ID) string { return "https://sgs.example.com/ws/" + id.Hash() }
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var posted []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q; want application/json", ct)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, body)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	ws := &model.Workspace{
+		ID:        1,
+		Nodegroup: model.NodegroupGraduate,
+		Quotas:    map[model.Resource]uint64{model.ResGPURequest: 2},
+		Provision: model.ProvisionStatus{State: model.ProvisionFailed, Message: "quota <exceeded>"},
+	}
+	url := "https://sgs.example.com/ws/" + ws.ID.Hash()
+	ns := []*model.Notification{
+		{Event: model.EventWorkspaceRequested, Workspace: ws, Username: "alice_b"},
+		{Event: model.EventWorkspaceApproved, Workspace: ws},
+		{Event: model.EventWorkspaceSyncFailed, Workspace: ws},
+		// not posted
+		{Event: model.EventMemberAdded, Workspace: ws, Username: "bob"},
+	}
+
+	tests := []struct {
+		format WebhookFormat
+		want   []map[string]string
+	}{
+		{FormatSlack, []map[string]string{
+			{"text": "New workspace request <" + url + "|" + ws.ID.Hash() + "> in graduate by alice_b, for 2 GPUs"},
+			{"text": "Workspace <" + url + "|" + ws.ID.Hash() + "> in graduate was approved, and is ready"},
+			{"text": "Provisioning workspace <" + url + "|" + ws.ID.Hash() + "> in graduate failed: quota &lt;exceeded&gt;"},
+		}},
+		{FormatMattermost, []map[string]string{
+			{"text": "New workspace request [" + ws.ID.Hash() + "](" + url + ") in graduate by alice\\_b, for 2 GPUs"},
+			{"text": "Workspace [" + ws.ID.Hash() + "](" + url + ") in graduate was approved, and is ready"},
+			{"text": "Provisioning workspace [" + ws.ID.Hash() + "](" + url + ") in graduate failed: quota \\<exceeded\\>"},
+		}},
+		{FormatDiscord, []map[string]string{
+			{"content": "New workspace request [" + ws.ID.Hash() + "](" + url + ") in graduate by alice\\_b, for 2 GPUs"},
+			{"content": "Workspace [" + ws.ID.Hash() + "](" + url + ") in graduate was approved, and is ready"},
+			{"content": "Provisioning workspace [" + ws.ID.Hash() + "](" + url + ") in graduate failed: quota \\<exceeded\\>"},
+		}},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		posted = nil
+		mu.Unlock()
+
+		wh := &Webhook{URL: srv.URL, Format: tt.format, Links: testLinks{}}
+		for _, n := range ns {
+			if err := wh.Notify(context.Background(), n); err != nil {
+				t.Fatalf("Notify(%s) = %v", n.Event, err)
+			}
+		}
+		mu.Lock()
+		if diff := cmp.Diff(tt.want, posted); diff != "" {
+			t.Errorf("%s messages mismatch\n%s", tt.format, diff)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestWebhookNodegroups(t *testing.T) {
+	t.Parallel()
+
+	var posts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	t.Cleanup(srv.Close)
+
+	whs := NewWebhooks([]WebhookConfig{
+		{URL: srv.URL, Format: FormatSlack, Nodegroups: []string{"undergraduate"}},
+	}, testLinks{})
+	for _, ng := range model.Nodegroups {
+		n := &model.Notification{Event: model.EventWorkspaceApproved, Workspace: &model.Workspace{ID: 1, Nodegroup: ng}}
+		if err := whs[0].Notifier.Notify(context.Background(), n); err != nil {
+			t.Fatalf("Notify() = %v", err)
+		}
+	}
+	if posts != 1 {
+		t.Errorf("posts = %d; want 1, of the undergraduate workspace", posts)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	wh := &Webhook{URL: srv.URL + "/services/T0/B0/token", Format: FormatSlack, Links: testLinks{}}
+	n := &model.Notification{Event: model.EventWorkspaceApproved, Workspace: &model.Workspace{ID: 1}}
+	err := wh.Notify(context.Background(), n)
+	if err == nil || !strings.Contains(err.Error(), "invalid_token") {
+		t.Fatalf("Notify() = %v; want the response body", err)
+	}
+
+	// the URL, with its token, is not part of errors
+	srv.Close()
+	err = wh.Notify(context.Background(), n)
+	if err == nil || strings.Contains(err.Error(), "token") {
+		t.Fatalf("Notify() = %v; want an error without the URL", err)
+	}
+}
+
+func TestWebhookReminder(t *testing.T) {
+	t.Parallel()
+
+	var body map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	t.Cleanup(srv.Close)
+
+	ws := &model.Workspace{ID: 1, Request: &model.WorkspaceUpdate{
+		ByUser:      "alice",
+		Nodegroup:   model.NodegroupUndergraduate,
+		RequestedAt: time.Date(2026, 1, 1, 9, 30, 0, 0, time.UTC),
+	}}
+	wh := &Webhook{URL: srv.URL, Format: FormatMattermost, Links: testLinks{}}
+	if err := wh.Notify(context.Background(), &model.Notification{Event: model.EventRequestStale, Workspace: ws}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	want := "Workspace request [" + ws.ID.Hash() + "](https://sgs.example.com/ws/" + ws.ID.Hash() + ") in undergraduate by alice is still pending, since 2026-01-01 09:30"
+	if body["text"] != want {
+		t.Errorf("text = %q; want %q", body["text"], want)
+	}
+}
@@ -0,0 +1,42 @@
+// Package secret reads secrets from files, so they need not be passed in
+// plain environment variables.
+package secret
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadFile returns the contents of the file, without trailing newlines.
+func ReadFile(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Load sets *value to the contents of file, if set. The file takes precedence
+// over the value.
+func Load(value *string, file string) error {
+	if file == "" {
+		return nil
+	}
+	s, err := ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading secret: %w", err)
+	}
+	*value = s
+	return nil
+}
+
+// Getenv returns the value of the environment variable key, or the contents
+// of the file named by key_FILE, if set.
+func Getenv(key string) (string, error) {
+	value := os.Getenv(key)
+	if err := Load(&value, os.Getenv(key+"_FILE")); err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", key, err)
+	}
+	return value, nil
+}
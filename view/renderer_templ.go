@@ -29,7 +29,9 @@ type ctxKey string
 const (
 	ctxKeyCSRF         ctxKey = "csrf"
 	ctxKeyUser         ctxKey = "user"
-	ctxKeyIsSubscribed ctxKey = "isSubscribed"
+	ctxKeyPolicy       ctxKey = "policy"
+	ctxKeyImpersonator ctxKey = "impersonator"
+	ctxKeyDocsURL      ctxKey = "docsURL"
 )
 
 func ctxCSRF(ctx context.Context) string {
@@ -50,11 +52,28 @@ func ctxUserOrNil(ctx context.Context) *auth.User {
 	return nil
 }
 
-func ctxIsSubscribed(ctx context.Context) bool {
-	if v := ctx.Value(ctxKeyIsSubscribed); v != nil {
-		return v.(bool)
+// ctxCan reports whether the current user may perform act on ws, see
+// auth.Policy.Can.
+func ctxCan(ctx context.Context, act auth.Action, ws *model.Workspace) bool {
+	policy, _ := ctx.Value(ctxKeyPolicy).(*auth.Policy)
+	if policy == nil {
+		return false
 	}
-	return false
+	return policy.Can(ctxUserOrNil(ctx), act, ws)
+}
+
+// ctxImpersonator returns the admin impersonating the current user, if any.
+func ctxImpersonator(ctx context.Context) *auth.User {
+	user, _ := ctx.Value(ctxKeyImpersonator).(*auth.User)
+	return user
+}
+
+// ctxDocsURL returns the URL of the user documentation.
+func ctxDocsURL(ctx context.Context) string {
+	if v := ctx.Value(ctxKeyDocsURL); v != nil {
+		return v.(string)
+	}
+	return ""
 }
 
 func (r renderer) Render(w io.Writer, _ string, data any, c echo.Context) error {
@@ -67,8 +86,14 @@ func (r renderer) Render(w io.Writer, _ string, data any, c echo.Context) error
 	if csrfToken := c.Get("csrf"); csrfToken != nil {
 		ctx = context.WithValue(ctx, ctxKeyCSRF, csrfToken)
 	}
-	if isSubscribed := c.Get("isSubscribed"); isSubscribed != nil {
-		ctx = context.WithValue(ctx, ctxKeyIsSubscribed, isSubscribed)
+	if policy := c.Get("policy"); policy != nil {
+		ctx = context.WithValue(ctx, ctxKeyPolicy, policy)
+	}
+	if impersonator := c.Get("impersonator"); impersonator != nil {
+		ctx = context.WithValue(ctx, ctxKeyImpersonator, impersonator)
+	}
+	if docsURL := c.Get("docsURL"); docsURL != nil {
+		ctx = context.WithValue(ctx, ctxKeyDocsURL, docsURL)
 	}
 
 	switch v := data.(type) {
@@ -138,7 +163,7 @@ func renderError(code int) templ.Component {
 			var templ_7745c5c3_Var3 string
 			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(strconv.Itoa(code))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `view/renderer.templ`, Line: 95, Col: 32}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `view/renderer.templ`, Line: 120, Col: 32}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 			if templ_7745c5c3_Err != nil {
@@ -151,7 +176,7 @@ func renderError(code int) templ.Component {
 			var templ_7745c5c3_Var4 string
 			templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(http.StatusText(code))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `view/renderer.templ`, Line: 96, Col: 28}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `view/renderer.templ`, Line: 121, Col: 28}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 			if templ_7745c5c3_Err != nil {
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Minimal Kubernetes objects rendered for each workspace, equivalent to the
+// deploy/chart Helm chart.
+type (
+	object struct {
+		APIVersion string         `yaml:"apiVersion"`
+		Kind       string         `yaml:"kind"`
+		Metadata   objectMetadata `yaml:"metadata"`
+		Spec       any            `yaml:"spec,omitempty"`
+		RoleRef    *roleRef       `yaml:"roleRef,omitempty"`
+		Subjects   *[]subject     `yaml:"subjects,omitempty"`
+	}
+	objectMetadata struct {
+		Namespace   string            `yaml:"namespace,omitempty"`
+		Name        string            `yaml:"name"`
+		Labels      map[string]string `yaml:"labels,omitempty"`
+		Annotations map[string]string `yaml:"annotations,omitempty"`
+	}
+
+	resourceQuotaSpec struct {
+		Hard map[string]string `yaml:"hard"`
+	}
+	limitRangeSpec struct {
+		Limits []limitRangeItem `yaml:"limits"`
+	}
+	limitRangeItem struct {
+		Type           string            `yaml:"type"`
+		Default        map[string]string `yaml:"default"`
+		Max            map[string]string `yaml:"max"`
+		DefaultRequest map[string]string `yaml:"defaultRequest"`
+	}
+
+	roleRef struct {
+		APIGroup string `yaml:"apiGroup"`
+		Kind     string `yaml:"kind"`
+		Name     string `yaml:"name"`
+	}
+	subject struct {
+		Kind string `yaml:"kind"`
+		Name string `yaml:"name"`
+	}
+)
+
+// Quotas of disabled workspaces, so nothing can be scheduled.
+var disabledQuotas = []string{
+	"limits.cpu", "limits.memory",
+	"requests.cpu", "requests.memory",
+	"requests.nvidia.com/gpu", "requests.nvidia.com/gpumem",
+	"requests.storage",
+}
+
+// RenderManifests writes the Kubernetes manifests of all workspaces to w, as a
+// multi-document YAML stream. The stream is never empty, so that
+// `kubectl apply --prune` works without any workspaces.
+func RenderManifests(w io.Writer, vwss []ValueWorkspace) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	// ensure the stream is always non-empty
+	objs := []object{{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Metadata:   objectMetadata{Name: "ws-empty"},
+	}}
+	for _, vws := range vwss {
+		objs = append(objs, workspaceObjects(vws)...)
+	}
+
+	for _, obj := range objs {
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+func renderManifests(vwss []ValueWorkspace) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := RenderManifests(&buf, vwss); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func workspaceObjects(vws ValueWorkspace) []object {
+	namespace := "ws-" + vws.IDHash
+	id := strconv.FormatInt(vws.ID, 10)
+	labels := map[string]string{"sgs.snucse.org/id": id}
+
+	ns := object{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Metadata:   objectMetadata{Name: namespace, Labels: labels},
+	}
+	if vws.Nodegroup != "graduate" {
+		ns.Metadata.Annotations = map[string]string{
+			"scheduler.alpha.kubernetes.io/node-selector": "node-restriction.kubernetes.io/nodegroup=" + vws.Nodegroup,
+		}
+	}
+
+	hard := map[string]string{
+		"requests.ephemeral-storage": "0",
+		"services.loadbalancers":     "0",
+		"services.nodeports":         "0",
+	}
+	limitDefault := map[string]string{"ephemeral-storage": "10Gi"}
+	subjects := []subject{}
+	if vws.Enabled {
+		for k, v := range vws.Quotas {
+			hard[k] = v
+		}
+		if v, ok := vws.Quotas["limits.cpu"]; ok {
+			limitDefault["cpu"] = v
+		}
+		if v, ok := vws.Quotas["limits.memory"]; ok {
+			limitDefault["memory"] = v
+		}
+		for _, user := range vws.Users {
+			subjects = append(subjects, subject{Kind: "User", Name: "id:" + user})
+		}
+	} else {
+		for _, k := range disabledQuotas {
+			hard[k] = "0"
+		}
+	}
+
+	return []object{
+		ns,
+		{
+			APIVersion: "v1",
+			Kind:       "ResourceQuota",
+			Metadata: objectMetadata{
+				Namespace: namespace,
+				Name:      "ws-quotas",
+				// named differently from the others, as it always was
+				Labels: map[string]string{"sgs.snucse.org/sgs-id": id},
+			},
+			Spec: resourceQuotaSpec{Hard: hard},
+		},
+		{
+			APIVersion: "v1",
+			Kind:       "LimitRange",
+			Metadata: objectMetadata{
+				Namespace: namespace,
+				Name:      "ws-quotas",
+				Labels:    labels,
+			},
+			Spec: limitRangeSpec{Limits: []limitRangeItem{{
+				Type:    "Container",
+				Default: limitDefault,
+				Max:     map[string]string{"ephemeral-storage": "10Gi"},
+				DefaultRequest: map[string]string{
+					"cpu":               "0",
+					"memory":            "0",
+					"ephemeral-storage": "0",
+				},
+			}}},
+		},
+		{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "RoleBinding",
+			Metadata: objectMetadata{
+				Namespace: namespace,
+				Name:      "ws-quotas",
+				Labels:    labels,
+			},
+			RoleRef: &roleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     "edit",
+			},
+			Subjects: &subjects,
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRenderManifests(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]ValueWorkspace{
+		"empty": nil,
+		"workspaces": {
+			{
+				ID:        42,
+				IDHash:    "eveajpbf7nxa3",
+				Enabled:   true,
+				Nodegroup: "undergraduate",
+				Quotas: map[string]string{
+					"limits.cpu":              "8",
+					"limits.memory":           "32Gi",
+					"requests.cpu":            "4",
+					"requests.memory":         "16Gi",
+					"requests.nvidia.com/gpu": "1",
+					"requests.storage":        "100Gi",
+				},
+				Users: []string{"alice", "bob"},
+			},
+			{
+				ID:        43,
+				IDHash:    "f3rmiiqadiz5f",
+				Enabled:   true,
+				Nodegroup: "graduate",
+				Quotas: map[string]string{
+					"requests.nvidia.com/gpu": "2",
+				},
+				Users: []string{"carol"},
+			},
+			{
+				ID:        44,
+				IDHash:    "yj1xoc1n1r6jd",
+				Enabled:   false,
+				Nodegroup: "undergraduate",
+				Quotas: map[string]string{
+					"requests.nvidia.com/gpu": "1",
+				},
+				Users: []string{"dave"},
+			},
+		},
+	}
+
+	for name, vwss := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := RenderManifests(&buf, vwss); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "manifests", name+".yaml")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(buf.String(), string(want)); diff != "" {
+				t.Fatalf("manifests mismatch (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+// Stage is a named step of a Pipeline.
+type Stage struct {
+	Name   string
+	Worker Worker
+
+	// Zero means no timeout of its own.
+	Timeout time.Duration
+	// Run the remaining stages even if this one fails.
+	ContinueOnError bool
+	// The worker applies nothing in dry runs, and may be previewed. Other
+	// stages are skipped by dry runs.
+	DryRun bool
+}
+
+// Pipeline runs stages in order, stopping at the first failing stage unless
+// it may continue on error. Every workspace gets a Result: it is ready only
+// if every stage succeeded for it.
+//
+// Dry runs preview every stage declaring DryRun, in order, and fail with
+// ErrDryRunUnsupported if there is none.
+func Pipeline(stages ...Stage) Worker {
+	return pipeline(stages)
+}
+
+type pipeline []Stage
+
+func (p pipeline) DryRun(ctx context.Context, vwss ValueWorkspaces) (string, error) {
+	if !slices.ContainsFunc(p, canDryRun) {
+		return "", ErrDryRunUnsupported
+	}
+
+	var (
+		out    strings.Builder
+		outErr error
+	)
+	for _, st := range p {
+		fmt.Fprintf(&out, "==> stage %s\n", st.Name)
+
+		if !canDryRun(st) {
+			out.WriteString("dry run not supported, skipped\n\n")
+			continue
+		}
+		preview, err := dryRunStage(ctx, st, st.Worker.(DryRunner), vwss)
+		out.WriteString(preview)
+		if preview != "" && !strings.HasSuffix(preview, "\n") {
+			out.WriteString("\n")
+		}
+		if err != nil {
+			fmt.Fprintf(&out, "failed: %v\n", err)
+			outErr = errors.Join(outErr, fmt.Errorf("stage %s: %w", st.Name, err))
+		}
+		out.WriteString("\n")
+	}
+	return out.String(), outErr
+}
+
+func (stages pipeline) Work(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+	// the first failure of each workspace is kept
+	failed := make(map[string]Result, len(vwss.Workspaces))
+	fail := func(res Result) {
+		if _, ok := failed[res.IDHash]; !ok {
+			failed[res.IDHash] = res
+		}
+	}
+
+	var outErr error
+	for i, st := range stages {
+		results, err := runStage(ctx, st, vwss)
+		if err != nil {
+			outErr = errors.Join(outErr, fmt.Errorf("stage %s: %w", st.Name, err))
+		}
+
+		reported := make(map[string]bool, len(results))
+		for _, res := range results {
+			reported[res.IDHash] = true
+			if res.Status != model.ProvisionReady {
+				res.Message = st.Name + ": " + res.Message
+				fail(res)
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		// workspaces without results share the failure of the stage, whose
+		// error may concern any workspace and is only logged
+		for _, vws := range vwss.Workspaces {
+			if !reported[vws.IDHash] {
+				fail(Result{
+					IDHash:  vws.IDHash,
+					Status:  model.ProvisionFailed,
+					Message: st.Name + ": failed",
+				})
+			}
+		}
+
+		if !st.ContinueOnError {
+			for _, skipped := range stages[i+1:] {
+				log.Printf("worker: stage %s skipped", skipped.Name)
+				for _, vws := range vwss.Workspaces {
+					fail(Result{
+						IDHash:  vws.IDHash,
+						Status:  model.ProvisionFailed,
+						Message: skipped.Name + ": skipped after " + st.Name + " failed",
+					})
+				}
+			}
+			break
+		}
+	}
+
+	results := make([]Result, len(vwss.Workspaces))
+	for i, vws := range vwss.Workspaces {
+		results[i] = Result{IDHash: vws.IDHash, Status: model.ProvisionReady}
+		if res, ok := failed[vws.IDHash]; ok {
+			results[i] = res
+		}
+	}
+	return results, outErr
+}
+
+// canDryRun reports whether st may be previewed. Workers must not only
+// support dry runs, but also be declared to honour them.
+func canDryRun(st Stage) bool {
+	_, ok := st.Worker.(DryRunner)
+	return ok && st.DryRun
+}
+
+func dryRunStage(ctx context.Context, st Stage, dr DryRunner, vwss ValueWorkspaces) (string, error) {
+	if st.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.Timeout)
+		defer cancel()
+	}
+	return dr.DryRun(ctx, vwss)
+}
+
+func runStage(ctx context.Context, st Stage, vwss ValueWorkspaces) ([]Result, error) {
+	if st.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	results, err := st.Worker.Work(ctx, vwss)
+	elapsed := time.Since(start)
+
+	failures := 0
+	for _, res := range results {
+		if res.Status != model.ProvisionReady {
+			failures++
+		}
+	}
+	if err != nil {
+		log.Printf("worker: stage %s failed after %v: %v", st.Name, elapsed, err)
+	} else {
+		log.Printf("worker: stage %s succeeded after %v, %d workspace failures", st.Name, elapsed, failures)
+	}
+	recordStage(st.Name, elapsed, err, failures)
+
+	return results, err
+}
+
+// Per-stage metrics, published at /debug/vars.
+var (
+	stageMetrics   = expvar.NewMap("worker_stages")
+	stageMetricsMu sync.Mutex
+)
+
+func recordStage(name string, elapsed time.Duration, err error, failures int) {
+	stageMetricsMu.Lock()
+	defer stageMetricsMu.Unlock()
+
+	m, ok := stageMetrics.Get(name).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map)
+		stageMetrics.Set(name, m)
+	}
+
+	m.Add("runs", 1)
+	if err != nil {
+		m.Add("errors", 1)
+	}
+	m.Add("workspace_failures", int64(failures))
+
+	duration := new(expvar.Float)
+	duration.Set(elapsed.Seconds())
+	m.Set("last_duration_seconds", duration)
+	success := new(expvar.Int)
+	if err == nil {
+		success.Set(1)
+	}
+	m.Set("last_success", success)
+}
@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+func TestPipeline(t *testing.T) {
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{
+			{ID: 1, IDHash: "a"},
+			{ID: 2, IDHash: "b"},
+			{ID: 3, IDHash: "c"},
+		},
+	}
+
+	var ran []string
+	stage := func(name string, results []Result, err error, cont bool) Stage {
+		return Stage{
+			Name: name,
+			Worker: WorkerFunc(func(ctx context.Context, _ ValueWorkspaces) ([]Result, error) {
+				ran = append(ran, name)
+				return results, err
+			}),
+			ContinueOnError: cont,
+		}
+	}
+
+	p := Pipeline(
+		stage("first", []Result{
+			{IDHash: "a", Status: model.ProvisionFailed, Message: "oops"},
+		}, nil, false),
+		stage("second", []Result{
+			{IDHash: "c", Status: model.ProvisionReady},
+		}, errors.New("broken"), true),
+		stage("third", nil, nil, false),
+	)
+
+	got, err := p.Work(context.Background(), vwss)
+	if err == nil || !strings.Contains(err.Error(), "stage second: broken") {
+		t.Fatalf("err = %v; want stage second: broken", err)
+	}
+	if diff := cmp.Diff(ran, []string{"first", "second", "third"}); diff != "" {
+		t.Fatalf("ran = mismatch\n%s", diff)
+	}
+
+	want := []Result{
+		{IDHash: "a", Status: model.ProvisionFailed, Message: "first: oops"},
+		{IDHash: "b", Status: model.ProvisionFailed, Message: "second: failed"},
+		{IDHash: "c", Status: model.ProvisionReady},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("results = mismatch\n%s", diff)
+	}
+}
+
+func TestPipelineStop(t *testing.T) {
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{
+			{ID: 1, IDHash: "a"},
+		},
+	}
+
+	called := false
+	p := Pipeline(
+		Stage{
+			Name: "slow",
+			Worker: WorkerFunc(func(ctx context.Context, _ ValueWorkspaces) ([]Result, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}),
+			Timeout: 10 * time.Millisecond,
+		},
+		Stage{
+			Name: "never",
+			Worker: WorkerFunc(func(ctx context.Context, _ ValueWorkspaces) ([]Result, error) {
+				called = true
+				return nil, nil
+			}),
+		},
+	)
+
+	got, err := p.Work(context.Background(), vwss)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if called {
+		t.Fatal("second stage ran after failure")
+	}
+
+	want := []Result{
+		{IDHash: "a", Status: model.ProvisionFailed, Message: "slow: failed"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("results = mismatch\n%s", diff)
+	}
+}
+
+func TestPipelineDryRun(t *testing.T) {
+	p := Pipeline(
+		Stage{Name: "apply", Worker: CmdWorker(`echo "namespace/ws-a created (dry run)"`), DryRun: true},
+		Stage{Name: "custom", Worker: WorkerFunc(func(ctx context.Context, _ ValueWorkspaces) ([]Result, error) {
+			t.Error("custom stage ran")
+			return nil, nil
+		}), DryRun: true},
+		// may ignore SGS_WORKER_DRY_RUN
+		Stage{Name: "undeclared", Worker: CmdWorker(`echo "applied"`)},
+		Stage{Name: "harbor", Worker: CmdWorker(`echo "no harbor"; exit 1`), DryRun: true},
+	)
+
+	got, err := p.(DryRunner).DryRun(context.Background(), ValueWorkspaces{})
+	if err == nil || !strings.Contains(err.Error(), "stage harbor") {
+		t.Errorf("err = %v; want stage harbor error", err)
+	}
+
+	want := `==> stage apply
+namespace/ws-a created (dry run)
+
+==> stage custom
+dry run not supported, skipped
+
+==> stage undeclared
+dry run not supported, skipped
+
+==> stage harbor
+no harbor
+failed: cmd worker: exit status 1
+
+`
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("preview mismatch\n%s", diff)
+	}
+}
+
+func TestPipelineDryRunUnsupported(t *testing.T) {
+	p := Pipeline(Stage{Name: "apply", Worker: CmdWorker(`echo "applied"`)})
+
+	got, err := p.(DryRunner).DryRun(context.Background(), ValueWorkspaces{})
+	if !errors.Is(err, ErrDryRunUnsupported) {
+		t.Fatalf("err = %v; want %v", err, ErrDryRunUnsupported)
+	}
+	if got != "" {
+		t.Fatalf("preview = %q; want none", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	// valid queue settings, to test the stages
+	cfg := func(c Config) Config {
+		c.Period = time.Minute
+		c.Timeout = time.Minute
+		return c
+	}
+
+	tests := map[string]struct {
+		cfg   Config
+		valid bool
+	}{
+		"command": {cfg(Config{Command: "true"}), true},
+		"stages": {cfg(Config{Stages: []StageConfig{
+			{Name: "apply", Command: "true"},
+			{Name: "harbor", Command: "true", Fields: []string{"idHash", "users"}},
+		}}), true},
+		"webhook": {cfg(Config{Stages: []StageConfig{
+			{Name: "sync", URL: "https://syncer.example.com/sync", Secret: "s", Retries: 2},
+		}}), true},
+		"empty":          {cfg(Config{}), false},
+		"cmd-and-url":    {cfg(Config{Stages: []StageConfig{{Name: "a", Command: "true", URL: "http://x"}}}), false},
+		"invalid-url":    {cfg(Config{Stages: []StageConfig{{Name: "a", URL: "syncer:8080"}}}), false},
+		"duplicate-name": {cfg(Config{Stages: []StageConfig{{Name: "a", Command: "true"}, {Name: "a", Command: "true"}}}), false},
+		"missing-cmd":    {cfg(Config{Stages: []StageConfig{{Name: "a"}}}), false},
+		"no-period":      {Config{Command: "true", Timeout: time.Minute}, false},
+		"short-max-backoff": {cfg(Config{
+			Command:         "true",
+			RetryBackoff:    time.Minute,
+			RetryMaxBackoff: time.Second,
+		}), false},
+		"manifests-fields": {cfg(Config{Stages: []StageConfig{
+			{Name: "a", Command: "true", Manifests: true, Fields: []string{"idHash"}},
+		}}), false},
+		"unknown-field": {cfg(Config{Stages: []StageConfig{{Name: "a", Command: "true", Fields: []string{"bogus"}}}}), false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err == nil) != tt.valid {
+				t.Fatalf("Validate() = %v; want valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
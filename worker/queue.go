@@ -2,37 +2,149 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
 )
 
+const (
+	// Reporting results happens after the worker ran, possibly after the
+	// timeout was exceeded. Give it some time of its own.
+	reportTimeout = 10 * time.Second
+
+	// Messages are shown to users, keep them reasonably short.
+	maxMessageLen = 1024
+
+	// Message of workspaces failed without a result of their own. The output
+	// of the worker covers every workspace in the run, and is only logged.
+	failedMessage = "provisioning failed"
+
+	// Delay before campaigning again after an election error.
+	electionRetryDelay = 5 * time.Second
+)
+
+// Whether this replica runs the queue, published at /debug/vars.
+var leaderMetric = expvar.NewInt("worker_leader")
+
+// Elector elects a single leader among replicas.
+type Elector interface {
+	// Lead blocks until elected or ctx is done, then runs fn with a context
+	// that is cancelled if leadership is lost. Leadership is handed over once
+	// fn returns.
+	Lead(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Cluster is a target of the Queue, provisioned by its own Worker.
+type Cluster struct {
+	Name   string
+	Worker Worker
+}
+
+// ClusterStatus is the outcome of the last run of a cluster.
+type ClusterStatus struct {
+	Name        string
+	Workspaces  int
+	LastRun     time.Time
+	LastSuccess time.Time
+	// Empty if the last run succeeded.
+	Error string
+}
+
 // Queue schedules Worker invocations. Multiple queue requests are coalesced
 // when made in quick succession.
 type Queue struct {
-	wsSvc model.WorkspaceService
-	work  Worker
+	wsSvc    model.WorkspaceService
+	clusters []Cluster
+	place    func(ws *model.Workspace) string
+
+	period     time.Duration
+	timeout    time.Duration
+	drift      time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	queue   chan struct{}
+	elector Elector
+	leader  atomic.Bool
+	shared  Worker
+
+	// state of the last successful run of each cluster and of the shared
+	// worker, only accessed by the main loop
+	synced       map[string]*syncState
+	sharedSynced *syncState
 
-	period  time.Duration
-	timeout time.Duration
+	statusMu sync.Mutex
+	statuses map[string]ClusterStatus
+}
+
+type syncState struct {
+	rev        int64
+	vwss       []ValueWorkspace
+	lastFullAt time.Time
+}
 
-	queue chan struct{}
+// NewQueue creates a new Queue for a single cluster. Runs are skipped if
+// nothing changed since the last successful one, unless the drift interval
+// has elapsed since the last full run. Failed runs are retried with
+// exponential backoff.
+func NewQueue(wsSvc model.WorkspaceService, work Worker, cfg Config) *Queue {
+	return NewClusterQueue(wsSvc, []Cluster{{Name: cluster.DefaultName, Worker: work}}, nil, cfg)
 }
 
-// NewQueue creates a new Queue.
-func NewQueue(wsSvc model.WorkspaceService, work Worker, period, timeout time.Duration) Queue {
-	return Queue{
-		wsSvc:   wsSvc,
-		work:    work,
-		period:  period,
-		timeout: timeout,
-		queue:   make(chan struct{}, 1),
+// NewClusterQueue creates a new Queue, running the worker of each cluster with
+// only the workspaces placed in it. A nil place puts every workspace in the
+// first cluster.
+func NewClusterQueue(wsSvc model.WorkspaceService, clusters []Cluster, place func(ws *model.Workspace) string, cfg Config) *Queue {
+	if place == nil {
+		place = func(*model.Workspace) string { return clusters[0].Name }
+	}
+	return &Queue{
+		wsSvc:      wsSvc,
+		clusters:   clusters,
+		place:      place,
+		period:     cfg.Period,
+		timeout:    cfg.Timeout,
+		drift:      cfg.DriftInterval,
+		backoff:    cfg.RetryBackoff,
+		maxBackoff: cfg.RetryMaxBackoff,
+		queue:      make(chan struct{}, 1),
+		synced:     make(map[string]*syncState, len(clusters)),
+		statuses:   make(map[string]ClusterStatus, len(clusters)),
 	}
 }
 
+// UseSharedWorker registers a Worker of resources shared by all clusters, such
+// as the registry. It runs with the workspaces of every cluster, once all of
+// them succeeded. Must be called before Start.
+func (q *Queue) UseSharedWorker(w Worker) {
+	q.shared = w
+}
+
+// UseElector makes the Queue only run on the elected leader among replicas.
+// Must be called before Start.
+func (q *Queue) UseElector(e Elector) {
+	q.elector = e
+}
+
+// IsLeader reports whether this replica runs the queue.
+func (q *Queue) IsLeader() bool {
+	return q.leader.Load()
+}
+
 // Enqueue a Worker invokcation.
-func (q Queue) Enqueue() {
+func (q *Queue) Enqueue() {
 	select {
 	case q.queue <- struct{}{}:
 	default:
@@ -41,46 +153,350 @@ func (q Queue) Enqueue() {
 }
 
 // Start the Queue loop. Exits and returns when the context is done.
-func (q Queue) Start(ctx context.Context) error {
-	// periodic enqueue
-	go func() {
-		tick := time.NewTicker(q.period)
-		defer tick.Stop()
-		for {
-			select {
-			case <-tick.C:
-				q.Enqueue()
-			case <-ctx.Done():
-				return
-			}
+func (q *Queue) Start(ctx context.Context) error {
+	if q.elector == nil {
+		q.setLeader(true)
+		defer q.setLeader(false)
+		return q.loop(ctx)
+	}
+
+	for {
+		err := q.elector.Lead(ctx, func(ctx context.Context) error {
+			log.Println("queue: elected leader")
+			q.setLeader(true)
+			defer q.setLeader(false)
+
+			// the previous leader may have left external resources in any
+			// state, start with a full sync
+			clear(q.synced)
+			q.sharedSynced = nil
+			q.Enqueue()
+			return q.loop(ctx)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Println("queue: not leading:", err)
+
+		select {
+		case <-time.After(electionRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (q *Queue) setLeader(leader bool) {
+	q.leader.Store(leader)
+	if leader {
+		leaderMetric.Set(1)
+	} else {
+		leaderMetric.Set(0)
+	}
+}
+
+func (q *Queue) loop(ctx context.Context) error {
+	tick := time.NewTicker(q.period)
+	defer tick.Stop()
+
+	// pending retry after failed runs, nil if none
+	var (
+		retry    *time.Timer
+		retryC   <-chan time.Time
+		failures int
+	)
+	defer func() {
+		if retry != nil {
+			retry.Stop()
 		}
 	}()
 
-	// main loop
 	for {
 		select {
 		case <-q.queue:
-			if err := q.run(ctx); err != nil {
-				log.Println("queue:", err)
+		case <-tick.C:
+			if retryC != nil {
+				// backing off, the retry takes the place of periodic runs
+				continue
 			}
+		case <-retryC:
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+
+		// any run supersedes the pending retry
+		if retry != nil {
+			retry.Stop()
+			retry, retryC = nil, nil
+		}
+
+		err := q.run(ctx)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		log.Println("queue:", err)
+		if ctx.Err() != nil || q.backoff <= 0 {
+			continue
+		}
+
+		failures++
+		delay := q.retryDelay(failures)
+		log.Printf("queue: retrying in %v (failure %d)", delay, failures)
+		retry = time.NewTimer(delay)
+		retryC = retry.C
+	}
+}
+
+// retryDelay is the exponential backoff after the given number of
+// consecutive failures, capped at maxBackoff, with equal jitter.
+func (q *Queue) retryDelay(failures int) time.Duration {
+	maxDelay := max(q.maxBackoff, q.backoff)
+	d := q.backoff
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// clusterRun is the outcome of running the worker of a cluster, or the shared
+// worker.
+type clusterRun struct {
+	wss     []*model.Workspace
+	vwss    ValueWorkspaces
+	full    bool
+	results []Result
+	err     error
+}
+
+// prepare sets up the input of the run in the named cluster, empty for the
+// shared worker, last synced as st.
+func (r *clusterRun) prepare(name string, st *syncState, full bool) {
+	r.full = full
+	r.vwss = toVWorkspaces(r.wss)
+	r.vwss.Cluster = name
+	if !full {
+		r.vwss.Previous = st.vwss
+	}
+}
+
+// syncState is the state after the run, prev if it failed.
+func (r *clusterRun) syncState(prev *syncState, rev int64, now time.Time) *syncState {
+	if r.err != nil {
+		return prev
 	}
+	st := &syncState{rev: rev, vwss: r.vwss.Workspaces, lastFullAt: now}
+	if !r.full {
+		st.lastFullAt = prev.lastFullAt
+	}
+	return st
 }
 
-func (q Queue) run(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+func (q *Queue) run(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, q.timeout)
 	defer cancel()
 
-	wss, err := q.wsSvc.ListCreatedWorkspaces(ctx)
+	// read the revision first, concurrent changes are picked up next time
+	rev, err := q.wsSvc.Revision(ctx)
 	if err != nil {
 		return err
 	}
-	err = q.work.Work(ctx, toVWorkspaces(wss))
+	now := time.Now()
+	needsFull := func(st *syncState) bool {
+		return st == nil || q.drift == 0 || now.Sub(st.lastFullAt) >= q.drift
+	}
+	needsRun := func(st *syncState) bool {
+		return needsFull(st) || st.rev != rev
+	}
+	if !slices.ContainsFunc(q.clusters, func(cl Cluster) bool { return needsRun(q.synced[cl.Name]) }) &&
+		(q.shared == nil || !needsRun(q.sharedSynced)) {
+		return nil
+	}
+
+	wss, err := q.wsSvc.ListCreatedWorkspaces(ctx)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	statuses := make(map[model.ID]model.ProvisionStatus, len(wss))
+	runs := make(map[string]*clusterRun, len(q.clusters))
+	for _, cl := range q.clusters {
+		runs[cl.Name] = &clusterRun{}
+	}
+	for _, ws := range wss {
+		name := q.place(ws)
+		r, ok := runs[name]
+		if !ok {
+			statuses[ws.ID] = model.ProvisionStatus{
+				State:   model.ProvisionFailed,
+				Message: fmt.Sprintf("unknown cluster %q", name),
+			}
+			continue
+		}
+		r.wss = append(r.wss, ws)
+	}
+
+	// clusters are independent, don't let one hold up the others
+	var wg sync.WaitGroup
+	for _, cl := range q.clusters {
+		r := runs[cl.Name]
+		st := q.synced[cl.Name]
+		r.prepare(cl.Name, st, needsFull(st))
+		wg.Go(func() {
+			r.results, r.err = cl.Worker.Work(ctx, r.vwss)
+		})
+	}
+	wg.Wait()
+
+	var workErr error
+	for _, cl := range q.clusters {
+		if r := runs[cl.Name]; r.err != nil {
+			workErr = errors.Join(workErr, fmt.Errorf("cluster %s: %w", cl.Name, r.err))
+		}
+	}
+
+	// Shared resources must not be removed for workspaces of other clusters,
+	// so the shared worker is passed all of them, even of unknown clusters.
+	// It runs after the clusters, whose resources it may depend on, and only
+	// if all of them succeeded. Until then, workspaces keep their state.
+	var shared *clusterRun
+	if q.shared != nil && workErr == nil && parent.Err() == nil {
+		shared = &clusterRun{wss: wss}
+		shared.prepare("", q.sharedSynced, needsFull(q.sharedSynced))
+		shared.results, shared.err = q.shared.Work(ctx, shared.vwss)
+		if shared.err != nil {
+			workErr = fmt.Errorf("shared: %w", shared.err)
+		}
+	}
+
+	if parent.Err() != nil {
+		// shutting down, the outcome says nothing about the workspaces
+		return workErr
+	}
+
+	for _, cl := range q.clusters {
+		r := runs[cl.Name]
+		q.synced[cl.Name] = r.syncState(q.synced[cl.Name], rev, now)
+		q.setStatus(cl.Name, len(r.wss), now, r.err)
+		maps.Copy(statuses, provisionStatuses(r.wss, r.results, r.err))
+	}
+	switch {
+	case shared != nil:
+		q.sharedSynced = shared.syncState(q.sharedSynced, rev, now)
+		for id, status := range provisionStatuses(wss, shared.results, shared.err) {
+			if status.State != model.ProvisionReady && statuses[id].State != model.ProvisionFailed {
+				statuses[id] = status
+			}
+		}
+	case q.shared != nil:
+		// not provisioned until the shared worker ran, keep the state
+		for id, status := range statuses {
+			if status.State == model.ProvisionReady {
+				delete(statuses, id)
+			}
+		}
+	}
+
+	rctx, rcancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
+	defer rcancel()
+
+	// workspaces becoming ready are announced through the outbox
+	if _, err := q.wsSvc.ReportProvisioning(rctx, statuses); err != nil {
+		return errors.Join(workErr, err)
+	}
+
+	return workErr
+}
+
+// Per-cluster status, published at /debug/vars.
+var clusterMetrics = expvar.NewMap("worker_clusters")
+
+func (q *Queue) setStatus(name string, workspaces int, now time.Time, err error) {
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+
+	st := q.statuses[name]
+	st.Name = name
+	st.Workspaces = workspaces
+	st.LastRun = now
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+	} else {
+		st.LastSuccess = now
+	}
+	q.statuses[name] = st
+
+	m := new(expvar.Map)
+	count := new(expvar.Int)
+	count.Set(int64(workspaces))
+	m.Set("workspaces", count)
+	success := new(expvar.Int)
+	if err == nil {
+		success.Set(1)
+	}
+	m.Set("last_success", success)
+	clusterMetrics.Set(name, m)
+}
+
+// ClusterStatuses returns the outcome of the last run of each cluster, in
+// order. Clusters that never ran have a zero LastRun.
+func (q *Queue) ClusterStatuses() []ClusterStatus {
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+
+	out := make([]ClusterStatus, len(q.clusters))
+	for i, cl := range q.clusters {
+		out[i] = q.statuses[cl.Name]
+		out[i].Name = cl.Name
+	}
+	return out
+}
+
+// DryRun previews the changes of provisioning ws in its cluster, which is
+// currently provisioned as cur, or not at all if cur is nil.
+func (q *Queue) DryRun(ctx context.Context, cur, ws *model.Workspace) (string, error) {
+	name := q.place(ws)
+	i := slices.IndexFunc(q.clusters, func(cl Cluster) bool { return cl.Name == name })
+	if i < 0 {
+		return "", fmt.Errorf("unknown cluster %q", name)
+	}
+	return dryRunWorkspace(ctx, q.clusters[i], cur, ws)
+}
+
+// provisionStatuses combines per-workspace results with the overall outcome.
+// Workspaces without a result are ready if the worker succeeded, failed with
+// failedMessage otherwise.
+func provisionStatuses(wss []*model.Workspace, results []Result, workErr error) map[model.ID]model.ProvisionStatus {
+	byHash := make(map[string]Result, len(results))
+	for _, res := range results {
+		byHash[res.IDHash] = res
+	}
+
+	statuses := make(map[model.ID]model.ProvisionStatus, len(wss))
+	for _, ws := range wss {
+		status := model.ProvisionStatus{State: model.ProvisionReady}
+		if res, ok := byHash[ws.ID.Hash()]; ok {
+			status = model.ProvisionStatus{State: res.Status, Message: res.Message}
+		} else if workErr != nil {
+			status = model.ProvisionStatus{State: model.ProvisionFailed, Message: failedMessage}
+		}
+		status.Message = truncateMessage(status.Message)
+		statuses[ws.ID] = status
+	}
+	return statuses
+}
+
+// truncateMessage sanitizes command output for storage.
+func truncateMessage(msg string) string {
+	msg = strings.ToValidUTF8(msg, "\uFFFD")
+	if len(msg) <= maxMessageLen {
+		return msg
+	}
+	n := maxMessageLen
+	for n > 0 && !utf8.RuneStart(msg[n]) {
+		n--
+	}
+	return msg[:n] + "..."
 }
@@ -3,6 +3,8 @@ package worker
 import (
 	"context"
 	"errors"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,19 +22,19 @@ func TestQueueCoalesce(t *testing.T) {
 
 	// slow worker
 	calls := 0
-	wf := func(ctx context.Context, vwss ValueWorkspaces) error {
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
 		t := time.NewTimer(time.Second)
 		defer t.Stop()
 		select {
 		case <-t.C:
 			calls++
-			return nil
+			return nil, nil
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
 	}
 
-	q := NewQueue(repo.Workspaces, WorkerFunc(wf), 5*time.Second, 5*time.Second)
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{Period: 5 * time.Second, Timeout: 5 * time.Second})
 
 	for range 10 {
 		q.Enqueue()
@@ -56,12 +58,12 @@ func TestQueuePeriod(t *testing.T) {
 	repo := mock.New()
 
 	calls := 0
-	wf := func(ctx context.Context, vwss ValueWorkspaces) error {
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
 		calls++
-		return nil
+		return nil, nil
 	}
 
-	q := NewQueue(repo.Workspaces, WorkerFunc(wf), time.Second, 5*time.Second)
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{Period: time.Second, Timeout: 5 * time.Second})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second/2)
 	t.Cleanup(cancel)
@@ -159,13 +161,13 @@ func TestQueue(t *testing.T) {
 
 	calls := 0
 	callVwss := ValueWorkspaces{}
-	wf := func(ctx context.Context, vwss ValueWorkspaces) error {
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
 		calls++
 		callVwss = vwss
-		return nil
+		return nil, nil
 	}
 
-	q := NewQueue(repo.Workspaces, WorkerFunc(wf), 5*time.Second, 5*time.Second)
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{Period: 5 * time.Second, Timeout: 5 * time.Second})
 	q.Enqueue()
 
 	if err := q.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
@@ -186,3 +188,452 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("callVwss.Workspaces[0] = mismatch\n%s", diff)
 	}
 }
+
+func TestQueueProvisioning(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+
+	repo := mock.New()
+
+	var ids []model.ID
+	for range 3 {
+		ws, err := repo.Workspaces.CreateWorkspace(ctx, &model.Workspace{
+			Nodegroup: model.NodegroupUndergraduate,
+			Users:     []model.WorkspaceUser{{Username: "user"}},
+		}, "test@example.com")
+		if err != nil {
+			t.Fatalf("CreateWorkspace err = %v; want nil", err)
+		}
+		_, err = repo.Workspaces.UpdateWorkspace(ctx, &model.WorkspaceUpdate{
+			WorkspaceID: ws.ID,
+			Enabled:     true,
+			Nodegroup:   ws.Nodegroup,
+			Users:       model.Usernames(ws.Users),
+		})
+		if err != nil {
+			t.Fatalf("UpdateWorkspace err = %v; want nil", err)
+		}
+		ids = append(ids, ws.ID)
+	}
+
+	// first one explicitly ready, second one failed, third one not reported
+	// with an overall failure
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+		return []Result{
+			{IDHash: ids[0].Hash(), Status: model.ProvisionReady},
+			{IDHash: ids[1].Hash(), Status: model.ProvisionFailed, Message: "harbor"},
+		}, errors.New("apply")
+	}
+
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{Period: 5 * time.Second, Timeout: 5 * time.Second})
+	q.Enqueue()
+
+	if err := q.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("q.Start err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	want := []model.ProvisionStatus{
+		{State: model.ProvisionReady},
+		{State: model.ProvisionFailed, Message: "harbor"},
+		{State: model.ProvisionFailed, Message: failedMessage},
+	}
+	for i, id := range ids {
+		ws, err := repo.Workspaces.GetWorkspace(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWorkspace err = %v; want nil", err)
+		}
+		if diff := cmp.Diff(ws.Provision, want[i]); diff != "" {
+			t.Errorf("ws[%d].Provision = mismatch\n%s", i, diff)
+		}
+	}
+}
+
+func TestQueueSkipUnchanged(t *testing.T) {
+	t.Parallel()
+
+	repo := mock.New()
+
+	var calls []ValueWorkspaces
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+		calls = append(calls, vwss)
+		return nil, nil
+	}
+
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{
+		Period:        time.Second,
+		Timeout:       5 * time.Second,
+		DriftInterval: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second/2)
+	t.Cleanup(cancel)
+
+	// change something between the second and third tick
+	var ws *model.Workspace
+	time.AfterFunc(5*time.Second/2, func() {
+		var err error
+		ws, err = repo.Workspaces.CreateWorkspace(ctx, &model.Workspace{
+			Nodegroup: model.NodegroupUndergraduate,
+			Users:     []model.WorkspaceUser{{Username: "user"}},
+		}, "test@example.com")
+		if err != nil {
+			t.Errorf("CreateWorkspace err = %v; want nil", err)
+			return
+		}
+		_, err = repo.Workspaces.UpdateWorkspace(ctx, &model.WorkspaceUpdate{
+			WorkspaceID: ws.ID,
+			Enabled:     true,
+			Nodegroup:   ws.Nodegroup,
+			Users:       model.Usernames(ws.Users),
+		})
+		if err != nil {
+			t.Errorf("UpdateWorkspace err = %v; want nil", err)
+		}
+	})
+
+	if err := q.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("q.Start err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// first tick is a full run, second is skipped, third is incremental
+	if len(calls) != 2 {
+		t.Fatalf("calls = %d; want 2", len(calls))
+	}
+	if calls[0].Previous != nil {
+		t.Errorf("calls[0].Previous = %v; want nil", calls[0].Previous)
+	}
+	if calls[1].Previous == nil || len(calls[1].Previous) != 0 {
+		t.Errorf("calls[1].Previous = %#v; want empty", calls[1].Previous)
+	}
+	if len(calls[1].Workspaces) != 1 || calls[1].Workspaces[0].ID != int64(ws.ID) {
+		t.Errorf("calls[1].Workspaces = %v; want [%d]", calls[1].Workspaces, ws.ID)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	t.Parallel()
+
+	repo := mock.New()
+
+	// fail twice, then succeed
+	calls := 0
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+		calls++
+		if calls <= 2 {
+			return nil, errors.New("apply failed")
+		}
+		return nil, nil
+	}
+
+	q := NewQueue(repo.Workspaces, WorkerFunc(wf), Config{
+		Period:          5 * time.Second,
+		Timeout:         5 * time.Second,
+		RetryBackoff:    100 * time.Millisecond,
+		RetryMaxBackoff: 200 * time.Millisecond,
+	})
+	q.Enqueue()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+
+	if err := q.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("q.Start err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	if calls != 3 {
+		t.Fatalf("calls = %d; want 3", calls)
+	}
+}
+
+func TestQueueRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	q := NewQueue(nil, nil, Config{RetryBackoff: time.Second, RetryMaxBackoff: 10 * time.Second})
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 10 {
+			if got := q.retryDelay(tt.failures); got < tt.want/2 || got > tt.want {
+				t.Errorf("retryDelay(%d) = %v; want in [%v, %v]", tt.failures, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+// tokenElector elects whoever takes the token first.
+type tokenElector chan struct{}
+
+func (e tokenElector) Lead(ctx context.Context, fn func(ctx context.Context) error) error {
+	select {
+	case e <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e }()
+	return fn(ctx)
+}
+
+func TestQueueLeader(t *testing.T) {
+	t.Parallel()
+
+	repo := mock.New()
+	elector := make(tokenElector, 1)
+
+	var calls [2]atomic.Int32
+	var queues [2]*Queue
+	for i := range queues {
+		wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+			calls[i].Add(1)
+			return nil, nil
+		}
+		queues[i] = NewQueue(repo.Workspaces, WorkerFunc(wf), Config{Period: time.Second, Timeout: 5 * time.Second})
+		queues[i].UseElector(elector)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second/2)
+	t.Cleanup(cancel)
+
+	// the leader starts with a full sync, and runs on every tick
+	errs := make(chan error, len(queues))
+	for _, q := range queues {
+		go func() { errs <- q.Start(ctx) }()
+	}
+	time.Sleep(time.Second / 2)
+	if queues[0].IsLeader() == queues[1].IsLeader() {
+		t.Fatalf("IsLeader() = %v, %v; want exactly one leader", queues[0].IsLeader(), queues[1].IsLeader())
+	}
+	for range queues {
+		if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("q.Start err = %v; want %v", err, context.DeadlineExceeded)
+		}
+	}
+
+	got := []int32{calls[0].Load(), calls[1].Load()}
+	if got[0] != 0 && got[1] != 0 {
+		t.Fatalf("calls = %v; want only the leader to run", got)
+	}
+	if got[0]+got[1] == 0 {
+		t.Fatalf("calls = %v; want the leader to run", got)
+	}
+	if queues[0].IsLeader() || queues[1].IsLeader() {
+		t.Fatal("IsLeader() = true after shutdown; want false")
+	}
+}
+
+func TestQueueClusters(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+
+	repo := mock.New()
+
+	// one workspace per nodegroup
+	var ids []model.ID
+	for _, ng := range []model.Nodegroup{model.NodegroupUndergraduate, model.NodegroupGraduate} {
+		ws, err := repo.Workspaces.CreateWorkspace(ctx, &model.Workspace{
+			Nodegroup: ng,
+			Users:     []model.WorkspaceUser{{Username: "user"}},
+		}, "test@example.com")
+		if err != nil {
+			t.Fatalf("CreateWorkspace err = %v; want nil", err)
+		}
+		_, err = repo.Workspaces.UpdateWorkspace(ctx, &model.WorkspaceUpdate{
+			WorkspaceID: ws.ID,
+			Enabled:     true,
+			Nodegroup:   ws.Nodegroup,
+			Users:       model.Usernames(ws.Users),
+		})
+		if err != nil {
+			t.Fatalf("UpdateWorkspace err = %v; want nil", err)
+		}
+		ids = append(ids, ws.ID)
+	}
+
+	// the undergraduate workspace goes to a cluster that does not exist
+	_, err := repo.Workspaces.AssignCluster(ctx, ids[0], "gone")
+	if err != nil {
+		t.Fatalf("AssignCluster err = %v; want nil", err)
+	}
+
+	var got []string
+	wf := func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+		for _, vws := range vwss.Workspaces {
+			got = append(got, vwss.Cluster+"/"+vws.IDHash)
+		}
+		return nil, nil
+	}
+	place := func(ws *model.Workspace) string {
+		if ws.Cluster != "" {
+			return ws.Cluster
+		}
+		return "main"
+	}
+
+	q := NewClusterQueue(repo.Workspaces, []Cluster{
+		{Name: "main", Worker: WorkerFunc(wf)},
+	}, place, Config{Period: 5 * time.Second, Timeout: 5 * time.Second})
+	q.Enqueue()
+
+	if err := q.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("q.Start err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	if diff := cmp.Diff(got, []string{"main/" + ids[1].Hash()}); diff != "" {
+		t.Errorf("worked = mismatch\n%s", diff)
+	}
+
+	want := []model.ProvisionStatus{
+		{State: model.ProvisionFailed, Message: `unknown cluster "gone"`},
+		{State: model.ProvisionReady},
+	}
+	for i, id := range ids {
+		ws, err := repo.Workspaces.GetWorkspace(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWorkspace err = %v; want nil", err)
+		}
+		if diff := cmp.Diff(ws.Provision, want[i]); diff != "" {
+			t.Errorf("ws[%d].Provision = mismatch\n%s", i, diff)
+		}
+	}
+
+	statuses := q.ClusterStatuses()
+	if len(statuses) != 1 || statuses[0].Name != "main" || statuses[0].Workspaces != 1 || statuses[0].Error != "" {
+		t.Errorf("ClusterStatuses() = %+v; want main with 1 workspace", statuses)
+	}
+}
+
+func TestQueueSharedWorker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := mock.New()
+
+	// one workspace per cluster
+	var ids []model.ID
+	for _, ng := range []model.Nodegroup{model.NodegroupUndergraduate, model.NodegroupGraduate} {
+		ws, err := repo.Workspaces.CreateWorkspace(ctx, &model.Workspace{
+			Nodegroup: ng,
+			Users:     []model.WorkspaceUser{{Username: "user"}},
+		}, "test@example.com")
+		if err != nil {
+			t.Fatalf("CreateWorkspace err = %v; want nil", err)
+		}
+		_, err = repo.Workspaces.UpdateWorkspace(ctx, &model.WorkspaceUpdate{
+			WorkspaceID: ws.ID,
+			Enabled:     true,
+			Nodegroup:   ws.Nodegroup,
+			Users:       model.Usernames(ws.Users),
+		})
+		if err != nil {
+			t.Fatalf("UpdateWorkspace err = %v; want nil", err)
+		}
+		ids = append(ids, ws.ID)
+	}
+	place := func(ws *model.Workspace) string {
+		if ws.Nodegroup == model.NodegroupUndergraduate {
+			return "a"
+		}
+		return "b"
+	}
+
+	var (
+		got      []string
+		clusterB error
+	)
+	record := func(name string, err *error) Worker {
+		return WorkerFunc(func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+			for _, vws := range vwss.Workspaces {
+				got = append(got, name+"/"+vwss.Cluster+"/"+vws.IDHash)
+			}
+			if err != nil {
+				return nil, *err
+			}
+			return nil, nil
+		})
+	}
+	// the registry fails for the second workspace only
+	shared := WorkerFunc(func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+		record("shared", nil).Work(ctx, vwss)
+		return []Result{{IDHash: ids[1].Hash(), Status: model.ProvisionFailed, Message: "harbor: failed"}}, nil
+	})
+
+	q := NewClusterQueue(repo.Workspaces, []Cluster{
+		{Name: "a", Worker: record("cluster", nil)},
+		{Name: "b", Worker: record("cluster", &clusterB)},
+	}, place, Config{Timeout: 5 * time.Second})
+	q.UseSharedWorker(shared)
+
+	// each cluster only gets its own workspaces, the shared worker all of
+	// them, so it does not remove those of other clusters
+	if err := q.run(ctx); err != nil {
+		t.Fatalf("run err = %v; want nil", err)
+	}
+	want := []string{
+		"cluster/a/" + ids[0].Hash(),
+		"cluster/b/" + ids[1].Hash(),
+		"shared//" + ids[0].Hash(),
+		"shared//" + ids[1].Hash(),
+	}
+	slices.Sort(got)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("worked = mismatch\n%s", diff)
+	}
+	wantStatus := []model.ProvisionStatus{
+		{State: model.ProvisionReady},
+		{State: model.ProvisionFailed, Message: "harbor: failed"},
+	}
+	for i, id := range ids {
+		ws, err := repo.Workspaces.GetWorkspace(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWorkspace err = %v; want nil", err)
+		}
+		if diff := cmp.Diff(ws.Provision, wantStatus[i]); diff != "" {
+			t.Errorf("ws[%d].Provision = mismatch\n%s", i, diff)
+		}
+	}
+
+	// the shared worker waits for every cluster, and the workspaces of the
+	// others keep their state
+	got = nil
+	clusterB = errors.New("apply")
+	if _, err := repo.Workspaces.AssignCluster(ctx, ids[0], "a"); err != nil {
+		t.Fatalf("AssignCluster err = %v; want nil", err)
+	}
+	if err := q.run(ctx); err == nil {
+		t.Fatal("run err = nil; want cluster b failure")
+	}
+	want = []string{
+		"cluster/a/" + ids[0].Hash(),
+		"cluster/b/" + ids[1].Hash(),
+	}
+	slices.Sort(got)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("worked = mismatch\n%s", diff)
+	}
+	wantStatus = []model.ProvisionStatus{
+		{State: model.ProvisionPending},
+		{State: model.ProvisionFailed, Message: failedMessage},
+	}
+	for i, id := range ids {
+		ws, err := repo.Workspaces.GetWorkspace(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWorkspace err = %v; want nil", err)
+		}
+		if diff := cmp.Diff(ws.Provision, wantStatus[i]); diff != "" {
+			t.Errorf("ws[%d].Provision = mismatch\n%s", i, diff)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request body,
+// prefixed with "sha256=".
+const SignatureHeader = "X-SGS-Signature-256"
+
+const (
+	defaultWebhookTimeout = 30 * time.Second
+	webhookRetryDelay     = time.Second
+	maxWebhookResponse    = 1 << 20
+)
+
+// DryRunHeader is set to "true" on dry runs.
+const DryRunHeader = "X-SGS-Dry-Run"
+
+// Webhook POSTs the workspaces as JSON to URL. The response body is a JSON
+// object with the per-workspace results:
+//
+//	{"results": [{"idHash": "eveajpbf7nxa3", "status": "ready"}]}
+//
+// Dry runs must not apply any changes, and respond with a preview instead:
+//
+//	{"preview": "namespace/ws-eveajpbf7nxa3 configured (dry run)"}
+type Webhook struct {
+	URL string
+	// Key of the HMAC signature. Requests are unsigned if empty.
+	Secret []byte
+	// Timeout of each attempt, defaults to 30 seconds.
+	Timeout time.Duration
+	// Number of retries after network errors and 5xx responses.
+	Retries int
+
+	// JSON fields of each ValueWorkspace sent. Empty sends all fields.
+	Fields []string
+	Client *http.Client
+}
+
+type webhookResponse struct {
+	Results []Result `json:"results"`
+	Preview string   `json:"preview"`
+}
+
+func (wh *Webhook) Work(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+	resp, err := wh.send(ctx, vwss, false)
+	return resp.Results, err
+}
+
+func (wh *Webhook) DryRun(ctx context.Context, vwss ValueWorkspaces) (string, error) {
+	resp, err := wh.send(ctx, vwss, true)
+	return resp.Preview, err
+}
+
+func (wh *Webhook) send(ctx context.Context, vwss ValueWorkspaces, dryRun bool) (webhookResponse, error) {
+	b, err := encodeInput(vwss, wh.Fields)
+	if err != nil {
+		return webhookResponse{}, err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, retry, err := wh.post(ctx, b, dryRun)
+		if err == nil || !retry || attempt >= wh.Retries {
+			return resp, err
+		}
+		log.Printf("webhook worker: attempt %d failed, retrying in %v: %v", attempt+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return webhookResponse{}, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single attempt, reporting whether it may be retried on error.
+func (wh *Webhook) post(ctx context.Context, body []byte, dryRun bool) (webhookResponse, bool, error) {
+	var out webhookResponse
+
+	timeout := wh.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return out, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(wh.Secret, body))
+	}
+	if dryRun {
+		req.Header.Set(DryRunHeader, "true")
+	}
+
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return out, true, fmt.Errorf("webhook worker: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+	if err != nil {
+		return out, true, fmt.Errorf("webhook worker: reading response: %w", err)
+	}
+
+	decodeErr := json.Unmarshal(respBody, &out)
+	results := out.Results[:0]
+	for _, res := range out.Results {
+		if res.IDHash != "" && res.Status.Valid() {
+			results = append(results, res)
+		}
+	}
+	out.Results = results
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out, resp.StatusCode >= 500,
+			fmt.Errorf("webhook worker: %s; %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if decodeErr != nil {
+		return webhookResponse{}, false, fmt.Errorf("webhook worker: decoding response: %w", decodeErr)
+	}
+	return out, false, nil
+}
+
+// Sign returns the signature header value of body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+)
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{
+			{ID: 1, IDHash: "a", Users: []string{"alice"}},
+			{ID: 2, IDHash: "b", Users: []string{"bob"}},
+		},
+	}
+
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if got, want := r.Header.Get(SignatureHeader), Sign(secret, body); got != want {
+			t.Errorf("signature = %q; want %q", got, want)
+		}
+
+		var got ValueWorkspaces
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		if diff := cmp.Diff(got, vwss); diff != "" {
+			t.Errorf("body mismatch\n%s", diff)
+		}
+
+		// fail the first attempt
+		if attempts.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"results": [
+			{"idHash": "a", "status": "ready"},
+			{"idHash": "b", "status": "failed", "message": "no harbor"},
+			{"idHash": "c", "status": "bogus"}
+		]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	wh := &Webhook{URL: srv.URL, Secret: secret, Retries: 1}
+	got, err := wh.Work(context.Background(), vwss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Result{
+		{IDHash: "a", Status: model.ProvisionReady},
+		{IDHash: "b", Status: model.ProvisionFailed, Message: "no harbor"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("results mismatch\n%s", diff)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("attempts = %d; want 2", n)
+	}
+}
+
+func TestWebhookClientError(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	wh := &Webhook{URL: srv.URL, Retries: 3}
+	if _, err := wh.Work(context.Background(), ValueWorkspaces{}); err == nil {
+		t.Fatal("err = nil; want non-nil")
+	}
+	// 4xx responses are not retried
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("attempts = %d; want 1", n)
+	}
+}
+
+func TestWebhookDryRun(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(DryRunHeader) != "true" {
+			t.Errorf("%s = %q; want true", DryRunHeader, r.Header.Get(DryRunHeader))
+		}
+		w.Write([]byte(`{"preview": "namespace/ws-a configured (dry run)"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	wh := &Webhook{URL: srv.URL}
+	got, err := wh.DryRun(context.Background(), ValueWorkspaces{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "namespace/ws-a configured (dry run)"; got != want {
+		t.Fatalf("preview = %q; want %q", got, want)
+	}
+}
@@ -7,24 +7,47 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"net/url"
+	"os"
 	"os/exec"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 
 	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
+	"github.com/bacchus-snu/sgs/pkg/secret"
 )
 
 type Worker interface {
-	Work(ctx context.Context, vwss ValueWorkspaces) error
+	// Work brings external resources in sync with vwss. Results may be
+	// reported for any subset of the workspaces.
+	Work(ctx context.Context, vwss ValueWorkspaces) ([]Result, error)
 }
 
-type WorkerFunc func(ctx context.Context, vwss ValueWorkspaces) error
+// DryRunner is implemented by Workers with a dry-run flavour, which preview
+// the changes they would make without applying them.
+type DryRunner interface {
+	DryRun(ctx context.Context, vwss ValueWorkspaces) (string, error)
+}
+
+type WorkerFunc func(ctx context.Context, vwss ValueWorkspaces) ([]Result, error)
 
-func (wf WorkerFunc) Work(ctx context.Context, vwss ValueWorkspaces) error {
+func (wf WorkerFunc) Work(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
 	return wf(ctx, vwss)
 }
 
+// Result is the provisioning outcome of a single workspace, keyed by its
+// IDHash.
+type Result struct {
+	IDHash  string               `json:"idHash"`
+	Status  model.ProvisionState `json:"status"`
+	Message string               `json:"message,omitempty"`
+}
+
 type ValueWorkspace struct {
 	ID        int64             `json:"id"`
 	IDHash    string            `json:"idHash"`
@@ -35,7 +58,14 @@ type ValueWorkspace struct {
 }
 
 type ValueWorkspaces struct {
+	// Name of the cluster the workspaces are placed in, empty for shared
+	// workers, which are passed the workspaces of all clusters.
+	Cluster    string           `json:"cluster"`
 	Workspaces []ValueWorkspace `json:"workspaces"`
+
+	// Previous holds the workspaces of the last successful run, so workers may
+	// act incrementally. Nil (null) if a full sync is required.
+	Previous []ValueWorkspace `json:"previous"`
 }
 
 func toVWorkspace(ws *model.Workspace) ValueWorkspace {
@@ -61,45 +91,380 @@ func toVWorkspace(ws *model.Workspace) ValueWorkspace {
 }
 
 func toVWorkspaces(wss []*model.Workspace) ValueWorkspaces {
-	vwss := ValueWorkspaces{make([]ValueWorkspace, len(wss))}
+	vwss := ValueWorkspaces{Workspaces: make([]ValueWorkspace, len(wss))}
 	for i, ws := range wss {
 		vwss.Workspaces[i] = toVWorkspace(ws)
 	}
 	return vwss
 }
 
-func CmdWorker(command string) WorkerFunc {
-	return func(ctx context.Context, vwss ValueWorkspaces) error {
-		b, err := json.Marshal(vwss)
-		if err != nil {
-			return err
-		}
+// CmdWorker runs command with bash, passing the workspaces as JSON on stdin.
+// Each stdout line that is a JSON-encoded Result is collected, everything else
+// is logged.
+//
+// Dry runs set SGS_WORKER_DRY_RUN=true and SGS_DEPLOY_APPLY=false, and collect
+// all other output as the preview.
+func CmdWorker(command string) Worker {
+	return &cmdWorker{command: command}
+}
+
+// cmdWorker is CmdWorker with extra environment variables, only passing the
+// given fields of each ValueWorkspace (all if empty), or the rendered
+// manifests instead of JSON.
+type cmdWorker struct {
+	command   string
+	env       []string
+	fields    []string
+	manifests bool
+}
+
+func (w *cmdWorker) Work(ctx context.Context, vwss ValueWorkspaces) ([]Result, error) {
+	results, out, err := w.run(ctx, vwss, w.env)
+	if err != nil {
+		return results, errors.Join(
+			fmt.Errorf("cmd worker: %v; %v", err, string(out)),
+			ctx.Err())
+	}
+	log.Println(string(out))
+
+	return results, nil
+}
+
+func (w *cmdWorker) DryRun(ctx context.Context, vwss ValueWorkspaces) (string, error) {
+	env := append(slices.Clip(w.env), "SGS_WORKER_DRY_RUN=true", "SGS_DEPLOY_APPLY=false")
+	_, out, err := w.run(ctx, vwss, env)
+	if err != nil {
+		return string(out), errors.Join(fmt.Errorf("cmd worker: %w", err), ctx.Err())
+	}
+	return string(out), nil
+}
+
+// run returns the results, and all other output.
+func (w *cmdWorker) run(ctx context.Context, vwss ValueWorkspaces, env []string) ([]Result, []byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if w.manifests {
+		b, err = renderManifests(vwss.Workspaces)
+	} else {
+		b, err = encodeInput(vwss, w.fields)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "bash", "-c", w.command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	results, out := parseResults(stdout.Bytes())
+	out = append(out, stderr.Bytes()...)
+	return results, out, runErr
+}
+
+// valueFields are the JSON field names of ValueWorkspace.
+var valueFields = []string{"id", "idHash", "enabled", "nodegroup", "quotas", "users"}
+
+func encodeInput(vwss ValueWorkspaces, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return json.Marshal(vwss)
+	}
 
-		cmd := exec.CommandContext(ctx, "bash", "-c", command)
-		cmd.Stdin = bytes.NewReader(b)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			return errors.Join(
-				fmt.Errorf("cmd worker: %v; %v", err, string(out)),
-				ctx.Err())
+	filter := func(vws []ValueWorkspace) ([]map[string]json.RawMessage, error) {
+		if vws == nil {
+			return nil, nil
+		}
+		out := make([]map[string]json.RawMessage, len(vws))
+		for i, v := range vws {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			all := map[string]json.RawMessage{}
+			if err := json.Unmarshal(b, &all); err != nil {
+				return nil, err
+			}
+			out[i] = make(map[string]json.RawMessage, len(fields))
+			for _, f := range fields {
+				out[i][f] = all[f]
+			}
 		}
-		log.Println(string(out))
+		return out, nil
+	}
 
-		return nil
+	cur, err := filter(vwss.Workspaces)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := filter(vwss.Previous)
+	if err != nil {
+		return nil, err
 	}
+	return json.Marshal(map[string]any{
+		"cluster":    vwss.Cluster,
+		"workspaces": cur,
+		"previous":   prev,
+	})
+}
+
+// parseResults splits Results from other output lines.
+func parseResults(b []byte) ([]Result, []byte) {
+	var (
+		results []Result
+		out     []byte
+	)
+	for line := range bytes.Lines(b) {
+		var res Result
+		if err := json.Unmarshal(line, &res); err == nil && res.IDHash != "" && res.Status.Valid() {
+			results = append(results, res)
+			continue
+		}
+		out = append(out, line...)
+	}
+	return results, out
 }
 
 type Config struct {
-	Command string `mapstructure:"command"`
+	// Command is run as the only stage, if set. Otherwise Stages are run.
+	Command string        `mapstructure:"command"`
+	Stages  []StageConfig `mapstructure:"stages"`
+	// Command honours SGS_WORKER_DRY_RUN, and may be run to preview changes.
+	DryRun bool `mapstructure:"dry_run"`
+
+	// The worker is enqueued every Period, and each run is limited to
+	// Timeout.
+	Period  time.Duration `mapstructure:"period"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Without changes, the worker is only run once per DriftInterval to
+	// correct drift of external resources. Zero runs it on every tick.
+	DriftInterval time.Duration `mapstructure:"drift_interval"`
+
+	// Failed runs are retried after RetryBackoff, doubling up to
+	// RetryMaxBackoff for consecutive failures. Zero disables retries.
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	RetryMaxBackoff time.Duration `mapstructure:"retry_max_backoff"`
+}
+
+// StageConfig configures a single step of the worker pipeline, either a
+// command or a webhook.
+type StageConfig struct {
+	Name    string            `mapstructure:"name"`
+	Command string            `mapstructure:"command"`
+	Timeout time.Duration     `mapstructure:"timeout"`
+	Env     map[string]string `mapstructure:"env"`
+
+	// Webhook URL, instead of Command.
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"`
+	// SecretFile is read instead of Secret, if set. It is read once, so
+	// rotating it requires a restart, not SIGHUP.
+	SecretFile     string        `mapstructure:"secret_file"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	Retries        int           `mapstructure:"retries"`
+
+	// Run the stage once for all clusters, with all workspaces, after the
+	// cluster stages succeeded in every cluster. Stages of resources outside
+	// the clusters, such as the registry, must set it, or the run of each
+	// cluster would remove the resources of the others.
+	Shared bool `mapstructure:"shared"`
+	// Run the remaining stages even if this one fails.
+	ContinueOnError bool `mapstructure:"continue_on_error"`
+	// The stage applies nothing in dry runs: commands honour
+	// SGS_WORKER_DRY_RUN, webhooks the dry run header. Only such stages are
+	// run to preview changes.
+	DryRun bool `mapstructure:"dry_run"`
+	// JSON fields of each ValueWorkspace passed to the command. Empty passes
+	// all fields.
+	Fields []string `mapstructure:"fields"`
+	// Pass the rendered Kubernetes manifests to the command instead, as a
+	// multi-document YAML stream.
+	Manifests bool `mapstructure:"manifests"`
 }
 
 func (c *Config) Bind() {
 	viper.BindEnv("worker.command", "SGS_WORKER_COMMAND")
+	// JSON-encoded list of stages
+	viper.BindEnv("worker.stages", "SGS_WORKER_STAGES")
+	viper.BindEnv("worker.dry_run", "SGS_WORKER_DRY_RUN_SUPPORTED")
+	viper.BindEnv("worker.period", "SGS_WORKER_PERIOD")
+	viper.BindEnv("worker.timeout", "SGS_WORKER_TIMEOUT")
+	viper.BindEnv("worker.drift_interval", "SGS_WORKER_DRIFT_INTERVAL")
+	viper.BindEnv("worker.retry_backoff", "SGS_WORKER_RETRY_BACKOFF")
+	viper.BindEnv("worker.retry_max_backoff", "SGS_WORKER_RETRY_MAX_BACKOFF")
+
+	viper.SetDefault("worker.period", time.Minute)
+	viper.SetDefault("worker.timeout", 5*time.Minute)
+	viper.SetDefault("worker.drift_interval", time.Hour)
+	viper.SetDefault("worker.retry_backoff", 5*time.Second)
+	viper.SetDefault("worker.retry_max_backoff", 5*time.Minute)
 }
 
 func (c *Config) Validate() error {
+	var err error
+	if c.Command == "" && len(c.Stages) == 0 {
+		err = errors.Join(err, errors.New("command or stages is required"))
+	}
 	if c.Command == "" {
-		return fmt.Errorf("command is required")
+		names := make(map[string]bool, len(c.Stages))
+		for i, st := range c.Stages {
+			if st.Name == "" {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: name is required", i))
+			} else if names[st.Name] {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: duplicate name %q", i, st.Name))
+			}
+			names[st.Name] = true
+
+			if (st.Command == "") == (st.URL == "") {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: exactly one of command or url is required", i))
+			}
+			if serr := secret.Load(&c.Stages[i].Secret, st.SecretFile); serr != nil {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: secret_file: %w", i, serr))
+			}
+			if st.URL != "" {
+				if u, uerr := url.Parse(st.URL); uerr != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					err = errors.Join(err, fmt.Errorf("stages[%d]: invalid url %q", i, st.URL))
+				}
+			}
+			if st.Timeout < 0 || st.RequestTimeout < 0 {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: timeouts must not be negative", i))
+			}
+			if st.Retries < 0 {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: retries must not be negative", i))
+			}
+			if st.Manifests && (st.URL != "" || len(st.Fields) > 0) {
+				err = errors.Join(err, fmt.Errorf("stages[%d]: manifests are only passed to commands, without fields", i))
+			}
+			for _, f := range st.Fields {
+				if !slices.Contains(valueFields, f) {
+					err = errors.Join(err, fmt.Errorf("stages[%d]: unknown field %q", i, f))
+				}
+			}
+		}
+	}
+	if c.Period <= 0 {
+		err = errors.Join(err, errors.New("period must be positive"))
+	}
+	if c.Timeout <= 0 {
+		err = errors.Join(err, errors.New("timeout must be positive"))
+	}
+	if c.DriftInterval < 0 {
+		err = errors.Join(err, errors.New("drift_interval must not be negative"))
+	}
+	if c.RetryBackoff < 0 {
+		err = errors.Join(err, errors.New("retry_backoff must not be negative"))
+	}
+	if c.RetryBackoff > 0 && c.RetryMaxBackoff < c.RetryBackoff {
+		err = errors.Join(err, errors.New("retry_max_backoff must not be less than retry_backoff"))
+	}
+	return err
+}
+
+// Redacted returns a copy of c with webhook secrets redacted.
+func (c Config) Redacted() Config {
+	c.Stages = slices.Clone(c.Stages)
+	for i := range c.Stages {
+		if c.Stages[i].Secret != "" {
+			c.Stages[i].Secret = "REDACTED"
+		}
+	}
+	return c
+}
+
+// NewWorker builds the configured Worker, a Pipeline of all stages.
+func NewWorker(cfg Config) Worker {
+	return newWorker(cfg, nil)
+}
+
+// NewClusterWorker builds the Worker of a cluster, running its own command if
+// set, or the configured stages that are not shared otherwise. Commands are
+// passed the cluster in SGS_CLUSTER, SGS_CLUSTER_SERVER and
+// SGS_CLUSTER_CA_DATA.
+func NewClusterWorker(cfg Config, cl cluster.Cluster) Worker {
+	if cl.Command != "" {
+		cfg.Command, cfg.DryRun = cl.Command, cl.DryRun
+	}
+	cfg.Stages = slices.DeleteFunc(slices.Clone(cfg.Stages), func(st StageConfig) bool { return st.Shared })
+	return newWorker(cfg, []string{
+		"SGS_CLUSTER=" + cl.Name,
+		"SGS_CLUSTER_SERVER=" + cl.Server,
+		"SGS_CLUSTER_CA_DATA=" + cl.CAData,
+	})
+}
+
+// NewSharedWorker builds the Worker of the shared stages, or returns nil if
+// there are none.
+func NewSharedWorker(cfg Config) Worker {
+	if cfg.Command != "" {
+		return nil
+	}
+	cfg.Stages = slices.DeleteFunc(slices.Clone(cfg.Stages), func(st StageConfig) bool { return !st.Shared })
+	if len(cfg.Stages) == 0 {
+		return nil
+	}
+	return newWorker(cfg, nil)
+}
+
+func newWorker(cfg Config, env []string) Worker {
+	if cfg.Command != "" {
+		return Pipeline(Stage{
+			Name:   "command",
+			Worker: &cmdWorker{command: cfg.Command, env: env},
+			DryRun: cfg.DryRun,
+		})
+	}
+
+	stages := make([]Stage, len(cfg.Stages))
+	for i, st := range cfg.Stages {
+		stages[i] = Stage{
+			Name:            st.Name,
+			Timeout:         st.Timeout,
+			ContinueOnError: st.ContinueOnError,
+			DryRun:          st.DryRun,
+		}
+		if st.URL != "" {
+			stages[i].Worker = &Webhook{
+				URL:     st.URL,
+				Secret:  []byte(st.Secret),
+				Timeout: st.RequestTimeout,
+				Retries: st.Retries,
+				Fields:  st.Fields,
+			}
+			continue
+		}
+
+		stageEnv := slices.Clone(env)
+		for _, k := range slices.Sorted(maps.Keys(st.Env)) {
+			stageEnv = append(stageEnv, k+"="+st.Env[k])
+		}
+		stages[i].Worker = &cmdWorker{st.Command, stageEnv, st.Fields, st.Manifests}
+	}
+	return Pipeline(stages...)
+}
+
+// ErrDryRunUnsupported is returned when previewing with a Worker without a
+// dry-run flavour, or without stages declared to support it.
+var ErrDryRunUnsupported = errors.New("worker does not support dry runs")
+
+// dryRunWorkspace previews the changes of provisioning ws alone in cl, which
+// is currently provisioned as cur, or not at all if cur is nil.
+func dryRunWorkspace(ctx context.Context, cl Cluster, cur, ws *model.Workspace) (string, error) {
+	dr, ok := cl.Worker.(DryRunner)
+	if !ok {
+		return "", ErrDryRunUnsupported
+	}
+
+	vwss := toVWorkspaces([]*model.Workspace{ws})
+	vwss.Cluster = cl.Name
+	vwss.Previous = []ValueWorkspace{}
+	if cur != nil {
+		vwss.Previous = append(vwss.Previous, toVWorkspace(cur))
 	}
-	return nil
+	return dr.DryRun(ctx, vwss)
 }
@@ -8,6 +8,9 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/bacchus-snu/sgs/model"
+	"github.com/bacchus-snu/sgs/pkg/cluster"
 )
 
 func TestCmdWorker(t *testing.T) {
@@ -26,7 +29,7 @@ func TestCmdWorker(t *testing.T) {
 	}
 
 	w := CmdWorker(fmt.Sprintf("tee %s", f.Name()))
-	if err := w.Work(context.Background(), vwss); err != nil {
+	if _, err := w.Work(context.Background(), vwss); err != nil {
 		t.Fatal(err)
 	}
 
@@ -39,3 +42,143 @@ func TestCmdWorker(t *testing.T) {
 		t.Fatalf("output mismatch\n%s", diff)
 	}
 }
+
+func TestCmdWorkerResults(t *testing.T) {
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{
+			{ID: 1, IDHash: "a"},
+			{ID: 2, IDHash: "b"},
+		},
+	}
+
+	w := CmdWorker(`
+		echo "namespace/ws-a configured"
+		echo '{"idHash":"a","status":"ready"}'
+		echo '{"idHash":"b","status":"failed","message":"no harbor"}'
+		echo '{"idHash":"c","status":"bogus"}'
+		exit 1
+	`)
+	got, err := w.Work(context.Background(), vwss)
+	if err == nil {
+		t.Fatal("err = nil; want non-nil")
+	}
+
+	want := []Result{
+		{IDHash: "a", Status: model.ProvisionReady},
+		{IDHash: "b", Status: model.ProvisionFailed, Message: "no harbor"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("results mismatch\n%s", diff)
+	}
+}
+
+func TestCmdWorkerFields(t *testing.T) {
+	f, err := os.CreateTemp("", "worker-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	t.Cleanup(func() { f.Close() })
+
+	vwss := ValueWorkspaces{
+		Cluster: "main",
+		Workspaces: []ValueWorkspace{
+			{ID: 1, IDHash: "a", Users: []string{"alice"}},
+		},
+	}
+
+	w := &cmdWorker{
+		command: fmt.Sprintf(`[[ "$STAGE" = harbor ]] && cat > %s`, f.Name()),
+		env:     []string{"STAGE=harbor"},
+		fields:  []string{"idHash", "users"},
+	}
+	if _, err := w.Work(context.Background(), vwss); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]any{}
+	if err := json.NewDecoder(f).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"cluster": "main",
+		"workspaces": []any{
+			map[string]any{"idHash": "a", "users": []any{"alice"}},
+		},
+		"previous": nil,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("output mismatch\n%s", diff)
+	}
+}
+
+func TestCmdWorkerDryRun(t *testing.T) {
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{{ID: 1, IDHash: "a"}},
+	}
+
+	w := CmdWorker(`
+		echo "dry run: $SGS_WORKER_DRY_RUN, apply: $SGS_DEPLOY_APPLY"
+		echo '{"idHash":"a","status":"ready"}'
+	`)
+	got, err := w.(DryRunner).DryRun(context.Background(), vwss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "dry run: true, apply: false\n"
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("preview mismatch\n%s", diff)
+	}
+}
+
+func TestCmdWorkerManifests(t *testing.T) {
+	vwss := ValueWorkspaces{
+		Workspaces: []ValueWorkspace{{ID: 42, IDHash: "eveajpbf7nxa3", Nodegroup: "graduate"}},
+	}
+
+	// a preview shows the manifests passed on stdin
+	w := &cmdWorker{command: "cat", manifests: true}
+	got, err := w.DryRun(context.Background(), vwss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := renderManifests(vwss.Workspaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, string(want)); diff != "" {
+		t.Fatalf("input mismatch\n%s", diff)
+	}
+}
+
+func TestSharedStages(t *testing.T) {
+	cfg := Config{Stages: []StageConfig{
+		{Name: "apply", Command: `echo '{"idHash":"a","status":"failed","message":"'"$SGS_CLUSTER"'"}'`},
+		{Name: "harbor", Command: `echo '{"idHash":"a","status":"failed","message":"registry"}'`, Shared: true},
+	}}
+	vwss := ValueWorkspaces{Workspaces: []ValueWorkspace{{ID: 1, IDHash: "a"}}}
+
+	tests := []struct {
+		name string
+		w    Worker
+		want string
+	}{
+		{"cluster", NewClusterWorker(cfg, cluster.Cluster{Name: "main"}), "apply: main"},
+		{"shared", NewSharedWorker(cfg), "harbor: registry"},
+	}
+	for _, tt := range tests {
+		got, err := tt.w.Work(context.Background(), vwss)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got) != 1 || got[0].Message != tt.want {
+			t.Errorf("%s results = %v; want message %q", tt.name, got, tt.want)
+		}
+	}
+
+	if w := NewSharedWorker(Config{Stages: cfg.Stages[:1]}); w != nil {
+		t.Errorf("NewSharedWorker() = %v; want nil without shared stages", w)
+	}
+}